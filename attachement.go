@@ -1,23 +1,38 @@
 package ember
 
 import (
-	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 
 	"github.com/maja42/ember/internal"
 )
 
 // Attachments represent embedded data in an executable.
 type Attachments struct {
-	exeFile *os.File
-	offsets map[string]int64
-	sizes   map[string]int64
+	path    string // path of the executable; empty if unknown
+	exe     io.ReaderAt
+	closer  io.Closer         // closes exe; nil if the underlying reader is not owned
+	layers  []*internal.Layer // embedded layers, in the order of their location within the executable
+	overlay map[string]string // files of the overlay directory (name -> path); nil if unused
+	keys    keyCache
+
+	namesOnce sync.Once
+	names     []string // names of all attachments; built on first use
+
+	mu      sync.Mutex
+	closed  bool
+	handles int // number of open handles; if closed, the executable is closed once the last handle is closed
+
+	fsOnce sync.Once
+	fsIdx  *fsIndex // directory tree for fs.FS support; built on first use
 }
 
 // Open returns the attachments of the running executable.
-func Open() (*Attachments, error) {
+func Open(opts ...Option) (*Attachments, error) {
 	path, err := os.Executable()
 	if err != nil {
 		return nil, err
@@ -28,107 +43,239 @@ func Open() (*Attachments, error) {
 		// It is therefore optional, any errors are ignored.
 		path = p
 	}
-	return OpenExe(path)
+	return OpenExe(path, opts...)
 }
 
 // OpenExe returns the attachments of an arbitrary executable.
-func OpenExe(exePath string) (*Attachments, error) {
-	att := &Attachments{}
-
+func OpenExe(exePath string, opts ...Option) (*Attachments, error) {
 	exe, err := os.Open(exePath)
 	if err != nil {
 		return nil, err
 	}
-	att.exeFile = exe
-	dontClose := false
-	defer func() {
-		if !dontClose {
-			_ = exe.Close()
-		}
-	}()
-
-	// determine TOC location
-	tocOffset := internal.SeekBoundary(exe)
-	if tocOffset < 0 { // No attachments found
-		dontClose = true
-		return att, nil
+	stat, err := exe.Stat()
+	if err != nil {
+		_ = exe.Close()
+		return nil, err
 	}
-	nextBoundary := internal.SeekBoundary(exe)
-	if nextBoundary < 0 {
-		// first boundary was found, but the next one (indicating the end of TOC data) is missing.
-		return nil, newAttErr("corrupt attachment data (incomplete TOC)")
+
+	att, err := newAttachments(exe, stat.Size(), exePath, applyOptions(opts))
+	if err != nil {
+		_ = exe.Close()
+		return nil, withPath(err, exePath)
 	}
-	tocEndOffset := tocOffset + nextBoundary
-	tocSize := int(nextBoundary) - internal.BoundarySize
+	att.closer = exe
+	return att, nil
+}
+
+// NewReader returns the attachments of an executable that is accessed via an io.ReaderAt,
+// for example an executable held in memory, stored within an archive or in a remote blob.
+// size is the total size of the executable in bytes.
+//
+// The returned attachments do not own the reader. Closing them has no effect on the reader,
+// which must stay usable as long as the attachments are in use.
+func NewReader(r io.ReaderAt, size int64, opts ...Option) (*Attachments, error) {
+	return newAttachments(r, size, "", applyOptions(opts))
+}
 
-	// read TOC
-	if _, err := exe.Seek(tocOffset, io.SeekStart); err != nil {
+// NewReadSeeker returns the attachments of an executable that is accessed via an io.ReadSeeker.
+// All accesses are serialized, and the reader's position is changed arbitrarily.
+//
+// See NewReader for more information.
+func NewReadSeeker(rs io.ReadSeeker, opts ...Option) (*Attachments, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
 		return nil, err
 	}
+	return NewReader(&internal.ReadSeekerAt{RS: rs}, size, opts...)
+}
 
-	var jsonTOC = make([]byte, tocSize)
-	if _, err := io.ReadFull(exe, jsonTOC); err != nil {
+// newAttachments parses the attachments of the given executable, and applies the overlay directory.
+// path is only used for error reporting.
+func newAttachments(exe io.ReaderAt, exeSize int64, path string, o *options) (*Attachments, error) {
+	dir, err := o.overlayDir()
+	if err != nil {
 		return nil, err
 	}
-
-	var toc internal.TOC
-	if err := json.Unmarshal(jsonTOC, &toc); err != nil {
-		return nil, newAttErr("corrupt attachment data (invalid TOC)")
+	att, err := parseAttachments(exe, exeSize, path, o)
+	if err != nil {
+		return nil, err
 	}
-
-	// calc offsets
-	att.offsets = make(map[string]int64, len(toc))
-	att.sizes = make(map[string]int64, len(toc))
-	offset := tocEndOffset
-	for _, a := range toc {
-		att.offsets[a.Name] = offset
-		att.sizes[a.Name] = a.Size
-		offset += a.Size
+	if dir != "" {
+		if err := att.applyOverlay(dir); err != nil {
+			return nil, err
+		}
 	}
+	return att, nil
+}
 
-	// find trailing boundary
-	if _, err := exe.Seek(offset, io.SeekStart); err != nil {
-		return nil, err
+// parseAttachments parses the attachments of the given executable.
+// If the executable contains multiple layers, later layers override or remove the attachments of earlier ones.
+func parseAttachments(exe io.ReaderAt, exeSize int64, path string, o *options) (*Attachments, error) {
+	layers, err := internal.ParseLayers(exe, exeSize)
+	if err != nil {
+		return nil, parseErr(err)
+	}
+	att := &Attachments{
+		path:   path,
+		exe:    exe,
+		layers: layers,
+		keys:   keyCache{provider: o.keyProvider},
 	}
 
-	var trailer = make([]byte, internal.BoundarySize)
-	if _, err := io.ReadFull(exe, trailer); err != nil {
-		if err == io.EOF || err == io.ErrUnexpectedEOF { // offsets point outside executable (missing data?)
-			return nil, newAttErr("corrupt attachment data (offsets too large)")
+	if len(o.trustedKeys) > 0 {
+		if err := att.VerifySignature(o.trustedKeys...); err != nil {
+			return nil, err
+		}
+		if err := att.VerifyAll(); err != nil {
+			return nil, err
 		}
-		return nil, err
-	}
-	if !internal.IsBoundary(trailer) {
-		return nil, newAttErr("corrupt attachment data (invalid offsets)")
 	}
-
-	dontClose = true
 	return att, nil
 }
 
+// parseErrs maps errors of the internal package to the errors of this package.
+var parseErrs = map[error]error{
+	internal.ErrInvalidTOC:     ErrCorruptTOC,
+	internal.ErrIncompleteTOC:  ErrIncompleteTOC,
+	internal.ErrInvalidFooter:  ErrInvalidFooter,
+	internal.ErrInvalidOffsets: ErrInvalidOffsets,
+	internal.ErrTruncated:      ErrTruncated,
+}
+
+// parseErr converts an error that occurred while parsing the executable.
+func parseErr(err error) error {
+	var parseError *internal.ParseError
+	if !errors.As(err, &parseError) {
+		return err
+	}
+	cause := parseError.Err
+	if e, ok := parseErrs[cause]; ok {
+		cause = e
+	}
+	return newAttErr(cause, parseError.Name, parseError.Offset)
+}
+
 // Close the executable containing the attachments.
-// Close will return an error if it has already been called.
+// Close will return ErrClosed if it has already been called.
+//
+// Afterwards, readers returned by Reader fail with ErrClosed.
+// Handles returned by Open stay usable; the executable is closed once the last handle is closed.
+//
+// Attachments created via NewReader or NewReadSeeker do not own the underlying reader; closing them has no effect on it.
 func (a *Attachments) Close() error {
-	return a.exeFile.Close()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	a.closed = true
+	if a.handles > 0 { // closed by the last handle
+		return nil
+	}
+	return a.closeExe()
+}
+
+// closeExe closes the underlying executable, if owned.
+func (a *Attachments) closeExe() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// entry describes a single attachment and its location within the executable.
+type entry struct {
+	internal.Attachment
+	offset  int64
+	overlay string // path of the overlay file; empty if the attachment is embedded
+}
+
+// entry returns the attachment with the given name.
+// Overlay files take precedence over embedded attachments; later layers override earlier ones.
+func (a *Attachments) entry(name string) (*entry, bool) {
+	if path, ok := a.overlay[name]; ok {
+		return overlayEntry(name, path), true
+	}
+	for i := len(a.layers) - 1; i >= 0; i-- {
+		if att, offset, ok := a.layers[i].Lookup(name); ok {
+			return &entry{Attachment: att, offset: offset}, !att.Tombstone
+		}
+	}
+	return nil, false
+}
+
+// attachmentNames returns the names of all attachments in TOC order.
+// The list is built on first use, as it requires decoding all entries.
+func (a *Attachments) attachmentNames() []string {
+	a.namesOnce.Do(func() {
+		var names []string
+		var removed []bool
+		pos := make(map[string]int) // name -> position in names
+
+		add := func(name string, tombstone bool) {
+			i, ok := pos[name]
+			switch {
+			case !ok && !tombstone:
+				pos[name] = len(names)
+				names = append(names, name)
+				removed = append(removed, false)
+			case ok:
+				removed[i] = tombstone
+			}
+		}
+		for _, l := range a.layers {
+			for j := 0; j < l.Index.Len(); j++ {
+				e, _ := l.Entry(j)
+				add(e.Name, e.Tombstone)
+			}
+		}
+		overlay := make([]string, 0, len(a.overlay))
+		for name := range a.overlay {
+			overlay = append(overlay, name)
+		}
+		sort.Strings(overlay)
+		for _, name := range overlay {
+			add(name, false)
+		}
+
+		for i, name := range names {
+			if !removed[i] {
+				a.names = append(a.names, name)
+			}
+		}
+	})
+	return a.names
 }
 
 // List returns a list containing the names of all attachments.
+//
+// Names are returned in the order of the TOC, which is the order of the attachment data within the executable.
+// Attachments of later layers follow the ones of earlier layers; replaced attachments keep their position.
+// Files of the overlay directory that do not replace an attachment are listed at the end, in lexical order.
 func (a *Attachments) List() []string {
-	if len(a.offsets) == 0 { // no attachments
+	names := a.attachmentNames()
+	if len(names) == 0 { // no attachments
 		return nil
 	}
-	l := make([]string, len(a.offsets))
-	i := 0
-	for name := range a.offsets {
-		l[i] = name
-		i++
-	}
-	return l
+	return append([]string(nil), names...)
 }
 
 // Count returns the number of attachments.
 func (a *Attachments) Count() int {
-	return len(a.offsets)
+	return len(a.attachmentNames())
+}
+
+// Range calls fn for each attachment in the order of List, until fn returns false.
+// The reader is the same as returned by Reader.
+//
+// Range has the signature of iter.Seq2, so it can be used in range-over-func loops with newer versions of go.
+func (a *Attachments) Range(fn func(name string, r Reader) bool) {
+	for _, name := range a.attachmentNames() {
+		if !fn(name, a.Reader(name)) {
+			return
+		}
+	}
 }
 
 // Reader groups basic methods available on attachments.
@@ -139,23 +286,76 @@ type Reader interface {
 }
 
 // Reader returns a reader for a given attachment.
+// Compressed and encrypted attachments are decompressed and decrypted transparently.
+// If the attachment can not be decrypted (eg. because the key is not available), all reads fail.
+//
+// The reader is only valid until the attachments are closed; afterwards, all reads fail with ErrClosed.
+// Use Open to obtain a handle that stays valid until it is closed.
+//
 // Returns nil if no attachment with that name exists.
 func (a *Attachments) Reader(name string) Reader {
-	offset, ok := a.offsets[name]
+	e, ok := a.entry(name)
 	if !ok {
 		return nil
 	}
-	return io.NewSectionReader(a.exeFile, offset, a.sizes[name])
+	if e.overlay != "" && a.isClosed() {
+		return &errReader{err: ErrClosed, size: e.Size}
+	}
+	r, err := a.reader(e, guardedReaderAt{a})
+	if err != nil {
+		return &errReader{err: err, size: e.Size}
+	}
+	return r
 }
 
-// Size returns the size of a specific attachment in bytes.
+// reader returns a reader for the content of the given attachment, read from exe.
+func (a *Attachments) reader(e *entry, exe io.ReaderAt) (Reader, error) {
+	if e.overlay != "" {
+		return a.overlayReader(e)
+	}
+	data := io.NewSectionReader(exe, e.offset, e.Stored())
+
+	if e.Encryption != internal.EncryptionNone {
+		key, err := a.keys.key(e.KeyID)
+		if err != nil {
+			return nil, a.attErr(err, e)
+		}
+		dec, err := internal.NewDecryptor(data, e.Stored(), key, e.Salt)
+		if err != nil {
+			return nil, a.attErr(err, e)
+		}
+		data = io.NewSectionReader(dec, 0, dec.Size())
+	}
+	if e.Compression != internal.CompressionNone {
+		return newDecompressingReader(data, e.Compression, e.Size), nil
+	}
+	return data, nil
+}
+
+// Size returns the (uncompressed) size of a specific attachment in bytes.
 // Returns zero if no attachment with that name exists.
 func (a *Attachments) Size(name string) int64 {
-	return a.sizes[name]
+	if e, ok := a.entry(name); ok {
+		return e.Size
+	}
+	return 0
 }
 
-// Offset returns the offset of a specific attachment in bytes, in relation to the start of the go executable.
+// StoredSize returns the number of bytes a specific attachment occupies within the executable.
+// This differs from Size if the attachment is compressed.
 // Returns zero if no attachment with that name exists.
+func (a *Attachments) StoredSize(name string) int64 {
+	if e, ok := a.entry(name); ok {
+		return e.Stored()
+	}
+	return 0
+}
+
+// Offset returns the offset of a specific attachment in bytes, in relation to the start of the go executable.
+// Returns zero if no attachment with that name exists, or if it is served from the overlay directory.
 func (a *Attachments) Offset(name string) int64 {
-	return a.offsets[name]
+	if e, ok := a.entry(name); ok {
+		return e.offset
+	}
+	return 0
 }
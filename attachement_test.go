@@ -1,7 +1,9 @@
 package ember
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"io"
 	"os"
@@ -44,16 +46,23 @@ func TestAttachments(t *testing.T) {
 	att, err := OpenExe(path)
 	assert.NoError(t, err)
 
-	assert.Len(t, att.offsets, len(testTOC))
-	assert.Len(t, att.sizes, len(testTOC))
+	assert.Len(t, att.layers, 1)
 
 	t.Run("List()", func(t *testing.T) {
 		list := att.List()
-		assert.Len(t, list, len(testTOC))
+		assert.Equal(t, []string{"att1", "num2", "3", "four"}, list) // TOC order
+	})
 
-		for _, name := range []string{"att1", "num2", "3", "four"} {
-			assert.Contains(t, list, name)
-		}
+	t.Run("Range()", func(t *testing.T) {
+		var names []string
+		att.Range(func(name string, r Reader) bool {
+			names = append(names, name)
+			content, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, att.Size(name), int64(len(content)))
+			return len(names) < 3
+		})
+		assert.Equal(t, []string{"att1", "num2", "3"}, names)
 	})
 
 	t.Run("Count()", func(t *testing.T) {
@@ -213,8 +222,17 @@ func TestOpenExe_SecondBoundaryMissing(t *testing.T) {
 	file.Close()
 
 	att, err := OpenExe(file.Name())
-	assert.EqualError(t, err, "corrupt attachment data (incomplete TOC)")
+	assert.ErrorIs(t, err, ErrIncompleteTOC)
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.NotErrorIs(t, err, ErrCorrupt)
 	assert.Nil(t, att)
+
+	var attErr *AttErr
+	if assert.ErrorAs(t, err, &attErr) {
+		assert.Equal(t, file.Name(), attErr.Path)
+		assert.Equal(t, int64(100+internal.BoundarySize), attErr.Offset) // start of the TOC
+		assert.Empty(t, attErr.Name)
+	}
 }
 
 func TestOpenExe_BrokenTOC(t *testing.T) {
@@ -232,7 +250,9 @@ func TestOpenExe_BrokenTOC(t *testing.T) {
 	file.Close()
 
 	att, err := OpenExe(file.Name())
-	assert.EqualError(t, err, "corrupt attachment data (invalid TOC)")
+	assert.ErrorIs(t, err, ErrCorruptTOC)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.EqualError(t, err, file.Name()+": offset 124: corrupt attachment data (invalid TOC)")
 	assert.Nil(t, att)
 }
 
@@ -250,7 +270,8 @@ func TestOpenExe_offsetsToBig(t *testing.T) {
 	defer os.Remove(path)
 
 	att, err := OpenExe(path)
-	assert.EqualError(t, err, "corrupt attachment data (offsets too large)")
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.NotErrorIs(t, err, ErrCorrupt)
 	assert.Nil(t, att)
 }
 
@@ -268,6 +289,300 @@ func TestOpenExe_invalidOffsets(t *testing.T) {
 	defer os.Remove(path)
 
 	att, err := OpenExe(path)
-	assert.EqualError(t, err, "corrupt attachment data (invalid offsets)")
+	assert.ErrorIs(t, err, ErrInvalidOffsets)
+	assert.ErrorIs(t, err, ErrCorrupt)
 	assert.Nil(t, att)
 }
+
+func prepareFileWithFooter(t *testing.T, exe []byte, toc internal.TOC, attachments [][]byte) string {
+	file, err := os.CreateTemp("", "")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.Write(exe)
+	assert.NoError(t, err)
+	err = internal.WriteBoundary(file)
+	assert.NoError(t, err)
+
+	jsonTOC, err := json.Marshal(toc)
+	assert.NoError(t, err)
+	_, err = file.Write(jsonTOC)
+	assert.NoError(t, err)
+	err = internal.WriteBoundary(file)
+	assert.NoError(t, err)
+
+	for _, attachment := range attachments {
+		_, err = file.Write(attachment)
+		assert.NoError(t, err)
+	}
+	err = internal.WriteBoundary(file)
+	assert.NoError(t, err)
+
+	err = internal.WriteFooter(file, internal.Footer{
+		TOCOffset: int64(len(exe) + internal.BoundarySize),
+		TOCSize:   int64(len(jsonTOC)),
+		Version:   internal.FooterVersion,
+	})
+	assert.NoError(t, err)
+	return file.Name()
+}
+
+func TestOpenExe_footer(t *testing.T) {
+	// The executable itself contains a boundary, which would break scanning.
+	// Opening only succeeds if the footer is used to locate the TOC.
+	var exe bytes.Buffer
+	exe.WriteString("executable")
+	_ = internal.WriteBoundary(&exe)
+	exe.WriteString("more executable")
+
+	var testTOC = internal.TOC{
+		internal.Attachment{Name: "att1", Size: 3},
+		internal.Attachment{Name: "att2", Size: 4},
+	}
+	path := prepareFileWithFooter(t, exe.Bytes(), testTOC, [][]byte{[]byte("abc"), []byte("defg")})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	assert.Equal(t, 2, att.Count())
+	content, err := io.ReadAll(att.Reader("att2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "defg", string(content))
+}
+
+func TestOpenExe_invalidFooter(t *testing.T) {
+	var testTOC = internal.TOC{
+		internal.Attachment{Name: "att1", Size: 3},
+	}
+	path := prepareFileWithFooter(t, []byte("executable"), testTOC, [][]byte{[]byte("abc")})
+	defer os.Remove(path)
+
+	// corrupt the TOC offset within the footer
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	stat, err := file.Stat()
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte{1}, stat.Size()-internal.FooterSize)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	att, err := OpenExe(path)
+	assert.ErrorIs(t, err, ErrInvalidFooter)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.Nil(t, att)
+}
+
+func TestNewReader(t *testing.T) {
+	var testTOC = internal.TOC{
+		internal.Attachment{Name: "att1", Size: 3},
+		internal.Attachment{Name: "att2", Size: 4},
+	}
+	path := prepareFile(t, testTOC, [][]byte{[]byte("abc"), []byte("defg")})
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	check := func(t *testing.T, att *Attachments) {
+		assert.Equal(t, 2, att.Count())
+		content, err := io.ReadAll(att.Reader("att1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", string(content))
+		content, err = io.ReadAll(att.Reader("att2"))
+		assert.NoError(t, err)
+		assert.Equal(t, "defg", string(content))
+		assert.NoError(t, att.Close())
+	}
+
+	t.Run("NewReader()", func(t *testing.T) {
+		att, err := NewReader(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		check(t, att)
+	})
+
+	t.Run("NewReadSeeker()", func(t *testing.T) {
+		att, err := NewReadSeeker(bytes.NewReader(data))
+		assert.NoError(t, err)
+		check(t, att)
+	})
+
+	t.Run("footer", func(t *testing.T) {
+		path := prepareFileWithFooter(t, []byte("executable"), testTOC, [][]byte{[]byte("abc"), []byte("defg")})
+		defer os.Remove(path)
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+
+		att, err := NewReadSeeker(bytes.NewReader(data))
+		assert.NoError(t, err)
+		check(t, att)
+	})
+
+	t.Run("no attachments", func(t *testing.T) {
+		att, err := NewReader(bytes.NewReader([]byte("executable")), 10)
+		assert.NoError(t, err)
+		assert.Zero(t, att.Count())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := data[:len(data)-100-internal.BoundarySize-2] // trailing data, boundary and parts of att2
+		att, err := NewReadSeeker(bytes.NewReader(truncated))
+		assert.ErrorIs(t, err, ErrTruncated)
+
+		var attErr *AttErr
+		if assert.ErrorAs(t, err, &attErr) {
+			assert.Empty(t, attErr.Path)
+		}
+		assert.Nil(t, att)
+	})
+}
+
+// encodeJSONTOC serializes the TOC in the format of older ember versions.
+func encodeJSONTOC(toc internal.TOC) ([]byte, error) {
+	return json.Marshal(toc)
+}
+
+// appendLayer appends a layer with the given TOC and attachment data.
+func appendLayer(t *testing.T, buf *bytes.Buffer, encode func(internal.TOC) ([]byte, error), toc internal.TOC, attachments [][]byte, withFooter bool) {
+	assert.NoError(t, internal.WriteBoundary(buf))
+	tocOffset := buf.Len()
+	data, err := encode(toc)
+	assert.NoError(t, err)
+	buf.Write(data)
+	assert.NoError(t, internal.WriteBoundary(buf))
+	for _, attachment := range attachments {
+		buf.Write(attachment)
+	}
+	assert.NoError(t, internal.WriteBoundary(buf))
+
+	if withFooter {
+		assert.NoError(t, internal.WriteFooter(buf, internal.Footer{
+			TOCOffset: int64(tocOffset),
+			TOCSize:   int64(len(data)),
+			Version:   internal.FooterVersion,
+		}))
+	}
+}
+
+func TestNewReader_layers(t *testing.T) {
+	check := func(t *testing.T, data []byte) {
+		att, err := NewReader(bytes.NewReader(data), int64(len(data)))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"base", "replaced", "new"}, att.List())
+
+		for name, expected := range map[string]string{
+			"base":     "base",
+			"replaced": "new version",
+			"new":      "added",
+		} {
+			content, err := io.ReadAll(att.Reader(name))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		}
+	}
+
+	layers := func(footers ...bool) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("executable")
+		appendLayer(t, &buf, encodeJSONTOC, internal.TOC{
+			{Name: "base", Size: 4},
+			{Name: "replaced", Size: 11},
+			{Name: "removed", Size: 7},
+		}, [][]byte{[]byte("base"), []byte("old version"), []byte("removed")}, footers[0])
+		appendLayer(t, &buf, internal.EncodeTOC, internal.TOC{
+			{Name: "replaced", Size: 11},
+			{Name: "added", Size: 5},
+		}, [][]byte{[]byte("new version"), []byte("added")}, footers[1])
+		appendLayer(t, &buf, internal.EncodeTOC, internal.TOC{
+			{Name: "new", Size: 5},
+			{Name: "added", Tombstone: true},
+			{Name: "removed", Tombstone: true},
+		}, [][]byte{[]byte("added")}, footers[2])
+		return buf.Bytes()
+	}
+
+	t.Run("footers", func(t *testing.T) {
+		check(t, layers(true, true, true))
+	})
+	t.Run("first layer without footer", func(t *testing.T) {
+		check(t, layers(false, true, true))
+	})
+	t.Run("trailing data", func(t *testing.T) {
+		data := append(layers(true, true, true), "trailing data"...)
+		check(t, data)
+	})
+	t.Run("no footers", func(t *testing.T) {
+		check(t, layers(false, false, false))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data := layers(true, true, true)
+		_, err := NewReader(bytes.NewReader(data[:len(data)-50]), int64(len(data)-50))
+		assert.ErrorIs(t, err, ErrTruncated)
+	})
+}
+
+func TestNewReader_binaryTOC(t *testing.T) {
+	// binary TOCs are not scanned for their end, so names may contain the boundary
+	var boundary bytes.Buffer
+	assert.NoError(t, internal.WriteBoundary(&boundary))
+	name := "odd" + boundary.String()
+
+	for _, withFooter := range []bool{true, false} {
+		var buf bytes.Buffer
+		buf.WriteString("executable")
+		appendLayer(t, &buf, internal.EncodeTOC, internal.TOC{
+			{Name: "first", Size: 5},
+			{Name: name, Size: 3},
+		}, [][]byte{[]byte("hello"), []byte("odd")}, withFooter)
+		data := buf.Bytes()
+
+		att, err := NewReader(bytes.NewReader(data), int64(len(data)))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, 2, att.Count())
+		content, err := io.ReadAll(att.Reader(name))
+		assert.NoError(t, err)
+		assert.Equal(t, "odd", string(content))
+		assert.Nil(t, att.Reader("unknown"))
+	}
+}
+
+func TestNewReader_formatTooNew(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("executable")
+	appendLayer(t, &buf, internal.EncodeTOC, internal.TOC{{Name: "att", Size: 3}}, [][]byte{[]byte("att")}, true)
+	data := buf.Bytes()
+
+	// bump the TOC version
+	tocOffset := len("executable") + internal.BoundarySize
+	binary.LittleEndian.PutUint16(data[tocOffset+8:], internal.TOCVersion+1)
+
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, ErrFormatTooNew)
+	var attErr *AttErr
+	if assert.ErrorAs(t, err, &attErr) {
+		assert.Equal(t, int64(tocOffset), attErr.Offset)
+	}
+}
+
+func TestNewReader_footerTooNew(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("executable")
+	appendLayer(t, &buf, internal.EncodeTOC, internal.TOC{{Name: "att", Size: 3}}, [][]byte{[]byte("att")}, true)
+	data := buf.Bytes()
+
+	// bump the footer version; the layout of newer footers is unknown, so the executable must not be scanned instead
+	footerOffset := len(data) - internal.FooterSize
+	binary.LittleEndian.PutUint16(data[footerOffset+16:], internal.FooterVersion+1)
+
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, ErrFormatTooNew)
+	var attErr *AttErr
+	if assert.ErrorAs(t, err, &attErr) {
+		assert.Equal(t, int64(footerOffset), attErr.Offset)
+	}
+}
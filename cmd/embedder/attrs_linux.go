@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// copyAttributes applies the ownership and extended attributes of src to dst.
+// Attributes that can not be applied due to missing permissions or file system support are skipped.
+func copyAttributes(src, dst *os.File, stat fs.FileInfo) error {
+	if err := copyOwner(dst, stat); err != nil {
+		return err
+	}
+
+	names, err := xattr(func(buf []byte) (int, error) {
+		return syscall.Listxattr(src.Name(), buf)
+	})
+	if err != nil {
+		return ignoreUnsupported(err)
+	}
+	for _, name := range strings.Split(string(names), "\x00") {
+		if name == "" {
+			continue
+		}
+		value, err := xattr(func(buf []byte) (int, error) {
+			return syscall.Getxattr(src.Name(), name, buf)
+		})
+		if err == nil {
+			err = syscall.Setxattr(dst.Name(), name, value, 0)
+		}
+		if err := ignoreUnsupported(err); err != nil {
+			return &os.PathError{Op: "copy extended attribute " + name, Path: dst.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// xattr calls the given function with a sufficiently large buffer and returns the result.
+// The function must return the required size if called with an empty buffer.
+func xattr(fn func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := fn(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := fn(buf)
+		if err == syscall.ERANGE { // grew in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// ignoreUnsupported ignores errors caused by missing permissions or file system support.
+func ignoreUnsupported(err error) error {
+	switch {
+	case err == nil,
+		errors.Is(err, syscall.ENOTSUP),
+		errors.Is(err, syscall.EOPNOTSUPP),
+		errors.Is(err, syscall.ENODATA), // removed in the meantime
+		errors.Is(err, fs.ErrPermission):
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_copyAttributes(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	dstPath := filepath.Join(dir, "dst")
+	assert.NoError(t, os.WriteFile(srcPath, []byte("src"), 0755))
+	assert.NoError(t, os.WriteFile(dstPath, []byte("dst"), 0644))
+	if err := syscall.Setxattr(srcPath, "user.ember", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes are not supported: %s", err)
+	}
+
+	src, err := os.Open(srcPath)
+	assert.NoError(t, err)
+	defer src.Close()
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	defer dst.Close()
+	stat, err := src.Stat()
+	assert.NoError(t, err)
+
+	assert.NoError(t, copyAttributes(src, dst, stat))
+	value, err := xattr(func(buf []byte) (int, error) {
+		return syscall.Getxattr(dstPath, "user.ember", buf)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyAttributes applies the ownership of src to dst, where supported.
+// Extended attributes are only preserved on linux.
+func copyAttributes(_, dst *os.File, stat fs.FileInfo) error {
+	return copyOwner(dst, stat)
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/maja42/ember"
+	"github.com/maja42/ember/embedding"
+)
+
+// embed augments an executable with the attachments of a manifest.
+func embed(flags *flag.FlagSet, args []string) error {
+	var enc encodingFlags
+	var src sourceFlags
+	exePath := flags.String("exe", "", "Target executable that should be modified (windows or linux)")
+	var output outputFlags
+	output.register(flags)
+	attachmentList := flags.String("attachments", "attachments.json", "Path to a manifest (JSON or YAML) listing the attachments to embed. Relative paths within the manifest are resolved against its directory")
+	stdin := flags.String("stdin", "", "Embed the content of standard input (eg. a pipe) as attachment with the given name (optional)")
+	layer := flags.Bool("layer", false, "Append the attachments as a new layer to an executable that already contains attachments, instead of embedding them from scratch")
+	tombstones := flags.String("tombstones", "", "Comma-separated list of attachments to remove from previous layers (requires -layer)")
+	modTimes := flags.Bool("mtime", false, "Store the modification times of attachments (clamped to SOURCE_DATE_EPOCH, if set). Omitted by default for reproducible output")
+	remove := flags.Bool("remove", false, "Remove all attachments instead (deprecated; use 'embedder strip')")
+	src.register(flags)
+	enc.register(flags)
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+	if err := output.check(flags); err != nil {
+		return err
+	}
+	if *remove {
+		return strip(findCommand("strip").flagSet(), append([]string{"-exe", *exePath}, output.args()...))
+	}
+	if *attachmentList == "" && *stdin == "" && len(src.paths) == 0 { // nothing to do?
+		flags.Usage()
+		return errUsage
+	}
+
+	// the default attachment list is only used if nothing else is embedded
+	useList := *attachmentList != "" && *stdin == "" && len(src.paths) == 0
+	flags.Visit(func(f *flag.Flag) {
+		useList = useList || f.Name == "attachments"
+	})
+	manifest := &embedding.Manifest{Version: embedding.ManifestVersion}
+	if useList {
+		var err error
+		if manifest, err = LoadManifest(*attachmentList); err != nil {
+			return err
+		}
+	}
+	files, err := src.collect(nil)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(files) {
+		manifest.Attachments = append(manifest.Attachments, embedding.ManifestEntry{Name: name, Path: files[name]})
+	}
+	if *stdin != "" {
+		manifest.Attachments = append(manifest.Attachments, embedding.ManifestEntry{Name: *stdin, Path: embedding.StdinPath})
+	}
+	opts, err := enc.options()
+	if err != nil {
+		return err
+	}
+	if *tombstones != "" {
+		opts = append(opts, embedding.WithTombstones(strings.Split(*tombstones, ",")...))
+	}
+	if *modTimes {
+		opts = append(opts, embedding.WithFileModTimes())
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+
+	fmt.Printf("Augmenting %q --> %q\n", *exePath, output.target(*exePath))
+	err = output.write(*exePath, exe, func(out io.Writer) error {
+		if *layer {
+			return embedding.EmbedLayerManifest(out, exe, manifest, logger, opts...)
+		}
+		return embedding.EmbedManifest(out, exe, manifest, logger, opts...)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to embed files: %w", err)
+	}
+	fmt.Println("Finished")
+	return nil
+}
+
+// list prints the attachments of an executable.
+func list(flags *flag.FlagSet, args []string) error {
+	exePath := flags.String("exe", "", "Augmented executable")
+	long := flags.Bool("l", false, "Show sizes, encoding and layer of each attachment")
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+	bundle, err := inspect(exe, *exePath)
+	if err != nil {
+		return err
+	}
+
+	if !*long {
+		for _, att := range bundle.Attachments() {
+			fmt.Println(att.Name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SIZE\tSTORED\tCOMPRESSION\tENCRYPTION\tLAYER\t\tNAME")
+	for _, att := range bundle.Attachments() {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t\t%s\n", att.Size, att.StoredSize,
+			orDash(string(att.Compression)), orDash(att.Encryption), att.Layer, att.Name)
+	}
+	return w.Flush()
+}
+
+// orDash returns "-" for empty strings.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// keyProvider returns an option for decrypting attachments with the key stored at the given path.
+// Returns no option if the path is empty.
+func keyProvider(path string) ([]ember.Option, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := LoadEncryptionKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return []ember.Option{ember.WithKeyProvider(ember.KeyProviderFunc(func(string) ([]byte, error) {
+		return key, nil
+	}))}, nil
+}
+
+// extract writes attachments into a directory, or to standard output.
+func extract(flags *flag.FlagSet, args []string) error {
+	exePath := flags.String("exe", "", "Augmented executable")
+	dir := flags.String("dir", ".", "Directory into which attachments are extracted. Attachment names are treated as relative paths")
+	stdout := flags.Bool("stdout", false, "Write the content of a single attachment to standard output instead")
+	encryptionKey := flags.String("encryption-key", "", "Path to a file containing the 32-byte key for decrypting encrypted attachments (optional)")
+	skipIdentical := flags.Bool("skip-identical", false, "Skip attachments whose destination file already exists with identical content")
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+	names := flags.Args()
+	if *stdout && len(names) != 1 {
+		return usageErr(flags, "Exactly one attachment name is required with -stdout")
+	}
+
+	opts, err := keyProvider(*encryptionKey)
+	if err != nil {
+		return err
+	}
+	att, err := openAttachments(*exePath, opts...)
+	if err != nil {
+		return err
+	}
+	defer att.Close()
+
+	if *stdout {
+		r := att.VerifyingReader(names[0])
+		if r == nil {
+			return fmt.Errorf("Attachment %q not found", names[0])
+		}
+		if _, err := io.Copy(os.Stdout, r); err != nil && !errors.Is(err, ember.ErrNoDigest) {
+			return fmt.Errorf("Failed to extract %q: %w", names[0], err)
+		}
+		return nil
+	}
+
+	var extractOpts []ember.ExtractOption
+	if *skipIdentical {
+		extractOpts = append(extractOpts, ember.SkipIdentical())
+	}
+	if len(names) == 0 {
+		if err := att.ExtractAll(*dir, extractOpts...); err != nil {
+			return fmt.Errorf("Failed to extract attachments: %w", err)
+		}
+		fmt.Printf("Extracted %d attachments into %q\n", att.Count(), *dir)
+		return nil
+	}
+	for _, name := range names {
+		rel, err := safePath(name)
+		if err != nil {
+			return fmt.Errorf("Failed to extract %q: %w", name, err)
+		}
+		if err := att.Extract(name, filepath.Join(*dir, filepath.FromSlash(rel)), extractOpts...); err != nil {
+			return fmt.Errorf("Failed to extract %q: %w", name, err)
+		}
+	}
+	fmt.Printf("Extracted %d attachments into %q\n", len(names), *dir)
+	return nil
+}
+
+// safePath converts an attachment name into a relative, '/'-separated path, like ember.ExtractAll does.
+// Returns ember.ErrUnsafeName if the name is absolute, contains a drive letter or refers to a parent directory.
+func safePath(name string) (string, error) {
+	p := strings.ReplaceAll(name, `\`, "/")
+	if len(p) >= 2 && p[1] == ':' { // drive letter, like "C:"
+		return "", ember.ErrUnsafeName
+	}
+	if !fs.ValidPath(p) || p == "." {
+		return "", ember.ErrUnsafeName
+	}
+	return p, nil
+}
+
+// add adds attachments to an executable.
+// If the executable does not contain attachments yet, they are embedded from scratch.
+func add(flags *flag.FlagSet, args []string) error {
+	var enc encodingFlags
+	var src sourceFlags
+	exePath := flags.String("exe", "", "Target executable")
+	var output outputFlags
+	output.register(flags)
+	src.register(flags)
+	enc.register(flags)
+	enc.registerUnsigned(flags)
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+	if err := output.check(flags); err != nil {
+		return err
+	}
+	pairs, err := splitPairs("attachment", flags.Args())
+	if err != nil {
+		return err
+	}
+	files, err := src.collect(pairs)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	opts, err := enc.options()
+	if err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+	_, err = embedding.Inspect(exe)
+	if err != nil && !errors.Is(err, embedding.ErrNothingEmbedded) {
+		return fmt.Errorf("Failed to inspect %q: %w", *exePath, err)
+	}
+	augmented := err == nil
+	if _, err := exe.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("Failed to read executable %q: %w", *exePath, err)
+	}
+
+	fmt.Printf("Adding attachments %q --> %q\n", *exePath, output.target(*exePath))
+	err = output.write(*exePath, exe, func(out io.Writer) error {
+		if augmented {
+			return embedding.UpdateFiles(out, exe, embedding.FileChanges{Add: files}, logger, opts...)
+		}
+		return embedding.EmbedFiles(out, exe, files, logger, opts...)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to add attachments: %w", err)
+	}
+	fmt.Println("Finished")
+	return nil
+}
+
+// rm removes attachments from an executable.
+func rm(flags *flag.FlagSet, args []string) error {
+	var enc encodingFlags
+	exePath := flags.String("exe", "", "Augmented executable")
+	var output outputFlags
+	output.register(flags)
+	flags.StringVar(&enc.signingKey, "sign-key", "", "Path to a PEM-encoded Ed25519 private key for signing the remaining attachments (optional)")
+	flags.BoolVar(&enc.legacyTOC, "legacy-toc", false, "Write the TOC in the JSON format understood by older versions of ember")
+	enc.registerUnsigned(flags)
+	if err := parseFlags(flags, args, 1, "exe"); err != nil {
+		return err
+	}
+	if err := output.check(flags); err != nil {
+		return err
+	}
+	changes := embedding.Changes{Delete: flags.Args()}
+	opts, err := enc.options()
+	if err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+
+	fmt.Printf("Removing attachments %q --> %q\n", *exePath, output.target(*exePath))
+	err = output.write(*exePath, exe, func(out io.Writer) error {
+		return embedding.Update(out, exe, changes, logger, opts...)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to remove attachments: %w", err)
+	}
+	fmt.Println("Finished")
+	return nil
+}
+
+// update modifies individual attachments of an augmented executable.
+func update(flags *flag.FlagSet, args []string) error {
+	var enc encodingFlags
+	exePath := flags.String("exe", "", "Augmented executable that should be modified")
+	var output outputFlags
+	output.register(flags)
+	var addFiles, replace, rename, remove listFlag
+	flags.Var(&addFiles, "add", "Add a new attachment (name=path). Can be repeated")
+	flags.Var(&replace, "replace", "Replace the content of an existing attachment (name=path). Can be repeated")
+	flags.Var(&rename, "rename", "Rename an existing attachment (old=new). Can be repeated")
+	flags.Var(&remove, "delete", "Delete an existing attachment. Can be repeated")
+	enc.register(flags)
+	enc.registerUnsigned(flags)
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+	if err := output.check(flags); err != nil {
+		return err
+	}
+
+	changes := embedding.FileChanges{Delete: remove}
+	var err error
+	if changes.Add, err = splitPairs("-add", addFiles); err != nil {
+		return err
+	}
+	if changes.Replace, err = splitPairs("-replace", replace); err != nil {
+		return err
+	}
+	if changes.Rename, err = splitPairs("-rename", rename); err != nil {
+		return err
+	}
+	opts, err := enc.options()
+	if err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+
+	fmt.Printf("Updating %q --> %q\n", *exePath, output.target(*exePath))
+	err = output.write(*exePath, exe, func(out io.Writer) error {
+		return embedding.UpdateFiles(out, exe, changes, logger, opts...)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update attachments: %w", err)
+	}
+	fmt.Println("Finished")
+	return nil
+}
+
+// verify checks the digests, and optionally the signature, of all attachments.
+func verify(flags *flag.FlagSet, args []string) error {
+	exePath := flags.String("exe", "", "Augmented executable")
+	var publicKeys listFlag
+	flags.Var(&publicKeys, "public-key", "Path to a PEM-encoded Ed25519 public key. If set, the attachments must be signed by one of the given keys. Can be repeated")
+	encryptionKey := flags.String("encryption-key", "", "Path to a file containing the 32-byte key for decrypting encrypted attachments (optional)")
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+
+	keys := make([]ed25519.PublicKey, len(publicKeys))
+	for i, path := range publicKeys {
+		var err error
+		if keys[i], err = LoadPublicKey(path); err != nil {
+			return err
+		}
+	}
+	opts, err := keyProvider(*encryptionKey)
+	if err != nil {
+		return err
+	}
+	// the signature is checked separately, so that corrupt attachments are reported individually
+	att, err := openAttachments(*exePath, opts...)
+	if err != nil {
+		return err
+	}
+	defer att.Close()
+	if len(keys) > 0 {
+		if err := att.VerifySignature(keys...); err != nil {
+			return fmt.Errorf("Signature verification failed: %w", err)
+		}
+		fmt.Println("Signature valid")
+	}
+
+	failed := 0
+	for _, name := range att.List() {
+		err := att.Verify(name)
+		switch {
+		case err == nil:
+			fmt.Printf("OK\t%s\n", name)
+		case errors.Is(err, ember.ErrNoDigest):
+			fmt.Printf("NO DIGEST\t%s\n", name)
+		default:
+			fmt.Printf("FAILED\t%s: %s\n", name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d attachments failed verification", failed, att.Count())
+	}
+	return nil
+}
+
+// info prints details about the layers of an executable.
+func info(flags *flag.FlagSet, args []string) error {
+	exePath := flags.String("exe", "", "Augmented executable")
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+	bundle, err := inspect(exe, *exePath)
+	if err != nil {
+		return err
+	}
+	size, err := exe.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("Failed to read executable %q: %w", *exePath, err)
+	}
+
+	fmt.Printf("Executable size:   %d bytes\n", size)
+	fmt.Printf("Original size:     %d bytes\n", bundle.ExeSize)
+	fmt.Printf("Attachments:       %d\n", len(bundle.Attachments()))
+	for i, l := range bundle.Layers {
+		fmt.Printf("Layer %d:\n", i)
+		fmt.Printf("\tOffset:      %d (%d bytes)\n", l.Offset, l.End-l.Offset)
+		fmt.Printf("\tTOC:         offset %d, %d bytes, format %d\n", l.TOCOffset, l.TOCSize, l.TOCFormat)
+		fmt.Printf("\tEntries:     %d\n", l.Entries)
+		if l.PublicKey != nil {
+			fmt.Printf("\tSigned by:   %s\n", base64.StdEncoding.EncodeToString(l.PublicKey))
+		} else {
+			fmt.Printf("\tSigned by:   -\n")
+		}
+	}
+	if last := bundle.Layers[len(bundle.Layers)-1]; last.End < size {
+		fmt.Printf("Trailing data:     %d bytes\n", size-last.End)
+	}
+	return nil
+}
+
+// strip removes all attachments from an executable.
+func strip(flags *flag.FlagSet, args []string) error {
+	exePath := flags.String("exe", "", "Augmented executable")
+	var output outputFlags
+	output.register(flags)
+	if err := parseFlags(flags, args, 0, "exe"); err != nil {
+		return err
+	}
+	if err := output.check(flags); err != nil {
+		return err
+	}
+
+	exe, err := openExe(*exePath)
+	if err != nil {
+		return err
+	}
+	defer exe.Close()
+
+	fmt.Printf("Removing embedded content from %q --> %q\n", *exePath, output.target(*exePath))
+	err = output.write(*exePath, exe, func(out io.Writer) error {
+		return embedding.RemoveEmbedding(out, exe, logger)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to remove embedded content: %w", err)
+	}
+	fmt.Println("Finished")
+	return nil
+}
+
+// keygen creates a new key pair for signing attachments.
+func keygen(flags *flag.FlagSet, args []string) error {
+	out := flags.String("out", "ember_signing_key", "Path for the private key. The public key is stored next to it with the suffix '.pub'")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := embedding.GenerateSigningKey()
+	if err != nil {
+		return fmt.Errorf("Failed to generate key: %w", err)
+	}
+	if err := writeNewFile(*out, privateKey, 0600); err != nil {
+		return fmt.Errorf("Failed to write private key: %w", err)
+	}
+	if err := writeNewFile(*out+".pub", publicKey, 0644); err != nil {
+		return fmt.Errorf("Failed to write public key: %w", err)
+	}
+	fmt.Printf("Created private key %q and public key %q\n", *out, *out+".pub")
+	return nil
+}
+
+// writeNewFile writes data into a new file. Fails if the file already exists.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,95 +1,393 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/maja42/ember"
 	"github.com/maja42/ember/embedding"
 )
 
-// CommandLine configuration
-type CommandLine struct {
-	Executable      string
-	RemoveEmbedding bool
-	AttachmentList  string
-	Out             string
+// command is a subcommand of the embedder.
+type command struct {
+	name        string
+	args        string // positional arguments, shown in the usage
+	description string
+	run         func(flags *flag.FlagSet, args []string) error
 }
 
-// AttachmentList maps embedded files (arbitrary name) to paths where they can be found on the filesystem.
-type AttachmentList map[string]string
+var commands []*command
 
-// LoadAttachmentList loads the list of attachments from a json file.
-func LoadAttachmentList(path string) AttachmentList {
-	file, err := os.ReadFile(path)
-	if err != nil {
-		log.Panicf("Failed to open attachment list %q: %s", path, err)
+func init() {
+	// initialized here, as the help command refers to the list itself
+	commands = []*command{
+		{"embed", "", "Embed the files listed in a manifest, or -source directories, into an executable", embed},
+		{"list", "", "List the attachments of an executable", list},
+		{"extract", "[name...]", "Extract attachments (all, if no names are given) into a directory or to standard output", extract},
+		{"add", "[name=path...]", "Add files or -source directories to an executable, keeping existing attachments", add},
+		{"rm", "name...", "Remove attachments from an executable", rm},
+		{"update", "", "Add, replace, rename and delete attachments of an executable", update},
+		{"verify", "", "Verify the content (and optionally the signature) of all attachments", verify},
+		{"info", "", "Show details about the layers of attachments within an executable", info},
+		{"strip", "", "Remove all attachments, restoring the original executable", strip},
+		{"keygen", "", "Create a key pair for signing attachments", keygen},
+		{"help", "[command]", "Show help for a command", help},
 	}
+}
+
+// errUsage is returned if the command line is invalid. The usage has already been printed.
+var errUsage = errors.New("invalid usage")
 
-	var list AttachmentList
-	if err := json.Unmarshal(file, &list); err != nil {
-		log.Panicf("Failed to read attachment list: %s", err)
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("embedder: ")
+
+	err := run(os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		log.Fatal(err)
 	}
-	return list
 }
 
-func main() {
-	var cmd CommandLine
-	flag.StringVar(&cmd.Executable, "exe", "", "Target executable that should be modified (windows or linux)")
-	flag.BoolVar(&cmd.RemoveEmbedding, "remove", false, "If attachments should be removed from an already augmented executable")
-	flag.StringVar(&cmd.AttachmentList, "attachments", "attachments.json", "Path to JSON file containing a list of attachments to embed")
-	flag.StringVar(&cmd.Out, "out", "", "Path for the resulting executable")
-	flag.Parse()
-	if cmd.Executable == "" || cmd.Out == "" {
-		flag.Usage()
-		os.Exit(1)
-	}
-	if !cmd.RemoveEmbedding && cmd.AttachmentList == "" { // nothing to do?
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	// Open executable
-	exe, err := os.Open(cmd.Executable)
+// run executes the command selected by the given arguments (without the program name).
+func run(args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errUsage
+	}
+	if isHelpFlag(args[0]) {
+		usage()
+		return flag.ErrHelp
+	}
+	if strings.HasPrefix(args[0], "-") {
+		// invoked without subcommand, as supported by previous versions
+		args = append([]string{"embed"}, args...)
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage()
+		return errUsage
+	}
+	return cmd.run(cmd.flagSet(), args[1:])
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// flagSet returns a flag set for parsing the command's arguments.
+func (c *command) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: embedder %s [flags]", c.name)
+		if c.args != "" {
+			fmt.Fprintf(out, " %s", c.args)
+		}
+		fmt.Fprintf(out, "\n\n%s.\n\nFlags:\n", c.description)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// usage prints the list of commands.
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: embedder <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(out, "\nUse 'embedder help <command>' for more information about a command.\n")
+}
+
+// help shows the usage of a command.
+func help(flags *flag.FlagSet, args []string) error {
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		usage()
+		return nil
+	}
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		return fmt.Errorf("Unknown command %q", flags.Arg(0))
+	}
+	return cmd.run(cmd.flagSet(), []string{"-help"}) // prints the usage
+}
+
+// parseFlags parses the arguments of a command.
+// Prints the command's usage and returns errUsage if the arguments are invalid, if a required flag is missing,
+// or if the number of positional arguments is below minArgs.
+func parseFlags(flags *flag.FlagSet, args []string, minArgs int, required ...string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage // already reported by the flag set
+	}
+	for _, name := range required {
+		if flags.Lookup(name).Value.String() == "" {
+			return usageErr(flags, "Missing required flag -%s", name)
+		}
+	}
+	if flags.NArg() < minArgs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// usageErr prints the problem together with the command's usage, and returns errUsage.
+func usageErr(flags *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(flags.Output(), format+"\n\n", args...)
+	flags.Usage()
+	return errUsage
+}
+
+// LoadManifest loads the list of attachments from a JSON or YAML manifest.
+func LoadManifest(path string) (*embedding.Manifest, error) {
+	manifest, err := embedding.LoadManifest(path)
 	if err != nil {
-		log.Fatalf("Failed to open executable %q: %s", cmd.Executable, err)
+		return nil, fmt.Errorf("Failed to read manifest: %w", err)
 	}
-	defer exe.Close()
+	return manifest, nil
+}
 
-	// Open output
-	out, err := os.OpenFile(cmd.Out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0755)
+// LoadSigningKey loads a PEM-encoded Ed25519 private key.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	file, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to open output file %q: %s", cmd.Out, err)
+		return nil, fmt.Errorf("Failed to open signing key %q: %w", path, err)
 	}
-	defer func() {
-		_ = out.Close()
-		if err := recover(); err != nil { // execution failed; delete created output file
-			_ = os.Remove(cmd.Out)
-		}
-	}()
+	key, err := embedding.ParseSigningKey(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read signing key %q: %w", path, err)
+	}
+	return key, nil
+}
 
-	logger := func(format string, args ...interface{}) {
-		fmt.Printf("\t"+format+"\n", args...)
+// LoadPublicKey loads a PEM-encoded Ed25519 public key.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open public key %q: %w", path, err)
 	}
+	key, err := ember.ParsePublicKey(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read public key %q: %w", path, err)
+	}
+	return key, nil
+}
 
-	if cmd.RemoveEmbedding {
-		fmt.Printf("Removing embedded content from %q --> %q", cmd.Executable, cmd.Out)
+// LoadEncryptionKey loads a 32-byte encryption key (raw, hex- or base64-encoded).
+func LoadEncryptionKey(path string) ([]byte, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open encryption key %q: %w", path, err)
+	}
+	key, err := embedding.ParseEncryptionKey(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read encryption key %q: %w", path, err)
+	}
+	return key, nil
+}
+
+// encodingFlags configure how new attachments are stored.
+type encodingFlags struct {
+	compression     string
+	encryptionKey   string
+	encryptionKeyID string
+	signingKey      string
+	unsigned        bool
+	legacyTOC       bool
+}
+
+func (e *encodingFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&e.compression, "compression", "", "Compress new attachments with the given codec (optional). Supported: gzip, zstd")
+	flags.StringVar(&e.encryptionKey, "encryption-key", "", "Path to a file containing a 32-byte key (raw, hex- or base64-encoded) for encrypting new attachments (optional)")
+	flags.StringVar(&e.encryptionKeyID, "encryption-key-id", "", "Key ID stored alongside encrypted attachments, passed to the application's key provider (optional)")
+	flags.StringVar(&e.signingKey, "sign-key", "", "Path to a PEM-encoded Ed25519 private key for signing the attachments (optional). Use 'embedder keygen' to create one")
+	flags.BoolVar(&e.legacyTOC, "legacy-toc", false, "Write the TOC in the JSON format understood by older versions of ember")
+}
+
+// registerUnsigned registers the flag for removing the signature of existing attachments.
+func (e *encodingFlags) registerUnsigned(flags *flag.FlagSet) {
+	flags.BoolVar(&e.unsigned, "unsigned", false, "Remove the signature if the existing attachments are signed, instead of failing (mutually exclusive with -sign-key)")
+}
 
-		err = embedding.RemoveEmbedding(out, exe, logger)
+func (e *encodingFlags) options() ([]embedding.Option, error) {
+	opts := []embedding.Option{
+		embedding.WithCompression(embedding.Compression(e.compression)),
+	}
+	if e.encryptionKey != "" {
+		key, err := LoadEncryptionKey(e.encryptionKey)
 		if err != nil {
-			log.Panicf("Failed to remove embedded content: %s", err)
+			return nil, err
 		}
-	} else {
-		fmt.Printf("Augmenting %q --> %q", cmd.Executable, cmd.Out)
-
-		attachments := LoadAttachmentList(cmd.AttachmentList)
-		err = embedding.EmbedFiles(out, exe, attachments, logger)
+		opts = append(opts, embedding.WithEncryption(e.encryptionKeyID, key))
+	}
+	if e.signingKey != "" {
+		key, err := LoadSigningKey(e.signingKey)
 		if err != nil {
-			log.Panicf("Failed to embed files: %s", err)
+			return nil, err
+		}
+		opts = append(opts, embedding.WithSigningKey(key))
+	}
+	if e.unsigned {
+		opts = append(opts, embedding.WithoutSigning())
+	}
+	if e.legacyTOC {
+		opts = append(opts, embedding.WithLegacyTOC())
+	}
+	return opts, nil
+}
+
+// sourceFlags select directories and glob patterns to embed.
+type sourceFlags struct {
+	paths    listFlag
+	include  listFlag
+	exclude  listFlag
+	prefix   string
+	strip    string
+	symlinks string
+}
+
+func (s *sourceFlags) register(flags *flag.FlagSet) {
+	flags.Var(&s.paths, "source", "Embed a file, directory (recursively) or glob pattern. Attachment names are the relative paths. Can be repeated")
+	flags.Var(&s.include, "include", "Only embed files of -source matching the pattern (eg. '*.png' or 'img/**'). Can be repeated")
+	flags.Var(&s.exclude, "exclude", "Skip files and directories of -source matching the pattern. Can be repeated")
+	flags.StringVar(&s.prefix, "prefix", "", "Directory prepended to the names of attachments from -source")
+	flags.StringVar(&s.strip, "strip", "", "Leading directory removed from the names of attachments from -source")
+	flags.StringVar(&s.symlinks, "symlinks", "follow", "Handling of symbolic links within -source directories: follow, skip or error")
+}
+
+// collect returns the files of all sources, added to the given attachments.
+func (s *sourceFlags) collect(attachments map[string]string) (map[string]string, error) {
+	if len(s.paths) == 0 {
+		return attachments, nil
+	}
+	policies := map[string]embedding.SymlinkPolicy{
+		"follow": embedding.SymlinkFollow,
+		"skip":   embedding.SymlinkSkip,
+		"error":  embedding.SymlinkError,
+	}
+	policy, ok := policies[s.symlinks]
+	if !ok {
+		return nil, fmt.Errorf("Invalid value %q for -symlinks (expected follow, skip or error)", s.symlinks)
+	}
+
+	sources := make([]embedding.Source, len(s.paths))
+	for i, path := range s.paths {
+		sources[i] = embedding.Source{
+			Path:     path,
+			Include:  s.include,
+			Exclude:  s.exclude,
+			Strip:    s.strip,
+			Prefix:   s.prefix,
+			Symlinks: policy,
 		}
 	}
+	files, err := embedding.CollectFiles(sources...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to collect files: %w", err)
+	}
+	if attachments == nil {
+		return files, nil
+	}
+	for name, path := range files {
+		if other, ok := attachments[name]; ok {
+			return nil, fmt.Errorf("Attachment %q is provided by %q and %q", name, other, path)
+		}
+		attachments[name] = path
+	}
+	return attachments, nil
+}
+
+// listFlag collects the values of a flag that can be specified multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitPairs splits "key=value" pairs into a map.
+func splitPairs(flagName string, pairs []string) (map[string]string, error) {
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid value %q for %s (expected name=value)", pair, flagName)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// sortedKeys returns the keys of the map in lexical order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// openExe opens an executable for reading.
+func openExe(path string) (*os.File, error) {
+	exe, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open executable %q: %w", path, err)
+	}
+	return exe, nil
+}
+
+// openAttachments opens the attachments of an executable, ignoring any overlay directory.
+func openAttachments(path string, opts ...ember.Option) (*ember.Attachments, error) {
+	att, err := ember.OpenExe(path, append(opts, ember.WithoutOverlay())...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open attachments: %w", err)
+	}
+	return att, nil
+}
+
+// inspect parses the attachments of an executable.
+func inspect(exe io.ReadSeeker, path string) (*embedding.Bundle, error) {
+	bundle, err := embedding.Inspect(exe)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect %q: %w", path, err)
+	}
+	return bundle, nil
+}
 
-	fmt.Printf("Finished")
+// logger prints progress information, indented below the command's summary.
+func logger(format string, args ...interface{}) {
+	fmt.Printf("\t"+format+"\n", args...)
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/maja42/ember/embedding"
+	"github.com/stretchr/testify/assert"
+)
+
+// exeContent is a fake executable that passes the compatibility check.
+const exeContent = "executable content ~~MagicMarker for " + "maja42/ember/v1" + "~~ more content"
+
+// silence discards everything written to standard output and standard error until the test ends.
+func silence(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devNull, devNull
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		_ = devNull.Close()
+	})
+}
+
+// prepareExe writes a fake executable into a temporary directory.
+func prepareExe(t *testing.T) (dir, exePath string) {
+	silence(t)
+	dir = t.TempDir()
+	exePath = filepath.Join(dir, "app")
+	assert.NoError(t, os.WriteFile(exePath, []byte(exeContent), 0755))
+	return dir, exePath
+}
+
+// prepareAugmented returns the path of a fake executable with the attachments "a.txt" and "b.json".
+func prepareAugmented(t *testing.T) (dir, exePath string) {
+	dir, src := prepareExe(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content of a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"b":true}`), 0644))
+	exePath = filepath.Join(dir, "augmented")
+	assert.NoError(t, run([]string{"add", "-exe", src, "-out", exePath, "a.txt=" + filepath.Join(dir, "a.txt"), "b.json=" + filepath.Join(dir, "b.json")}))
+	return dir, exePath
+}
+
+// captureStdout returns everything written to standard output by fn.
+func captureStdout(t *testing.T, fn func()) string {
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer file.Close()
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	data, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	return string(data)
+}
+
+// corrupt modifies the stored data of an attachment.
+func corrupt(t *testing.T, exePath, name string) {
+	data, err := os.ReadFile(exePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	bundle, err := embedding.Inspect(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	info, ok := bundle.Attachment(name)
+	if assert.True(t, ok) {
+		data[info.Offset] ^= 0xff
+		assert.NoError(t, os.WriteFile(exePath, data, 0755))
+	}
+}
+
+// readAttachments returns the content of all attachments of an executable.
+func readAttachments(t *testing.T, exePath string) map[string]string {
+	att, err := ember.OpenExe(exePath, ember.WithoutOverlay())
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer att.Close()
+	contents := make(map[string]string)
+	for _, name := range att.List() {
+		content, err := io.ReadAll(att.Reader(name))
+		assert.NoError(t, err)
+		contents[name] = string(content)
+	}
+	return contents
+}
+
+func TestRun_dispatch(t *testing.T) {
+	_, exePath := prepareAugmented(t)
+
+	tests := map[string]struct {
+		args []string
+		err  error
+	}{
+		"no arguments":        {nil, errUsage},
+		"unknown command":     {[]string{"unknown"}, errUsage},
+		"help":                {[]string{"help"}, nil},
+		"help flag":           {[]string{"-h"}, flag.ErrHelp},
+		"help for command":    {[]string{"help", "list"}, flag.ErrHelp},
+		"command help flag":   {[]string{"list", "-help"}, flag.ErrHelp},
+		"missing flag":        {[]string{"list"}, errUsage},
+		"undefined flag":      {[]string{"list", "-exe", exePath, "-unknown"}, errUsage},
+		"missing arguments":   {[]string{"rm", "-exe", exePath, "-out", "unused"}, errUsage},
+		"missing output":      {[]string{"strip", "-exe", exePath}, errUsage},
+		"conflicting outputs": {[]string{"strip", "-exe", exePath, "-out", "unused", "-inplace"}, errUsage},
+		"list":                {[]string{"list", "-exe", exePath}, nil},
+		"info":                {[]string{"info", "-exe", exePath}, nil},
+		"verify":              {[]string{"verify", "-exe", exePath}, nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := run(test.args)
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		err := run([]string{"list", "-exe", filepath.Join(t.TempDir(), "missing")})
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.NotErrorIs(t, err, errUsage)
+
+		assert.EqualError(t, run([]string{"help", "unknown"}), `Unknown command "unknown"`)
+	})
+}
+
+func TestRun_legacy(t *testing.T) {
+	dir, exePath := prepareExe(t)
+	config := filepath.Join(dir, "config.json")
+	assert.NoError(t, os.WriteFile(config, []byte(`{"key":"value"}`), 0644))
+	manifest := filepath.Join(dir, "attachments.json")
+	assert.NoError(t, os.WriteFile(manifest, []byte(`{"config": `+strconv.Quote(config)+`}`), 0644))
+
+	// invoked without subcommand
+	out := filepath.Join(dir, "out")
+	err := run([]string{"-exe", exePath, "-attachments", manifest, "-out", out})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"config": `{"key":"value"}`}, readAttachments(t, out))
+
+	// -remove strips all attachments
+	stripped := filepath.Join(dir, "stripped")
+	err = run([]string{"-exe", out, "-remove", "-out", stripped})
+	assert.NoError(t, err)
+	content, err := os.ReadFile(stripped)
+	assert.NoError(t, err)
+	assert.Equal(t, exeContent, string(content))
+
+	err = run([]string{"-exe", out, "-remove"})
+	assert.ErrorIs(t, err, errUsage, "the output is required")
+}
+
+func TestRun_modify(t *testing.T) {
+	dir, exePath := prepareAugmented(t)
+	assert.Equal(t, map[string]string{
+		"a.txt":  "content of a",
+		"b.json": `{"b":true}`,
+	}, readAttachments(t, exePath))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("content of c"), 0600))
+	err := run([]string{"add", "-exe", exePath, "-inplace", "c.txt=" + filepath.Join(dir, "c.txt")})
+	assert.NoError(t, err)
+	att, err := ember.OpenExe(exePath, ember.WithoutOverlay())
+	if assert.NoError(t, err) {
+		stat, err := att.Stat("c.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm(), "file metadata is stored")
+		assert.Equal(t, "text/plain; charset=utf-8", att.ContentType("c.txt"))
+		assert.NoError(t, att.Close())
+	}
+
+	err = run([]string{"update", "-exe", exePath, "-inplace", "-rename", "a.txt=renamed.txt", "-delete", "b.json"})
+	assert.NoError(t, err)
+	err = run([]string{"rm", "-exe", exePath, "-inplace", "c.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"renamed.txt": "content of a"}, readAttachments(t, exePath))
+
+	err = run([]string{"update", "-exe", exePath, "-inplace", "-rename", "invalid"})
+	assert.EqualError(t, err, `Invalid value "invalid" for -rename (expected name=value)`)
+}
+
+func TestRun_extract(t *testing.T) {
+	dir, exePath := prepareAugmented(t)
+	dst := filepath.Join(dir, "extracted")
+
+	assert.NoError(t, run([]string{"extract", "-exe", exePath, "-dir", dst, "a.txt"}))
+	content, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content of a", string(content))
+	assert.NoFileExists(t, filepath.Join(dst, "b.json"))
+
+	err = run([]string{"extract", "-exe", exePath, "-dir", dst, "missing"})
+	assert.ErrorIs(t, err, ember.ErrNotFound)
+	err = run([]string{"extract", "-exe", exePath, "-stdout"})
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestRun_verify(t *testing.T) {
+	dir, src := prepareExe(t)
+	key := filepath.Join(dir, "key")
+	assert.NoError(t, run([]string{"keygen", "-out", key}))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content of a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"b":true}`), 0644))
+	exePath := filepath.Join(dir, "signed")
+	err := run([]string{"add", "-exe", src, "-out", exePath, "-sign-key", key, "a.txt=" + filepath.Join(dir, "a.txt"), "b.json=" + filepath.Join(dir, "b.json")})
+	assert.NoError(t, err)
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, run([]string{"verify", "-exe", exePath, "-public-key", key + ".pub"}))
+	})
+	assert.Equal(t, "Signature valid\nOK\ta.txt\nOK\tb.json\n", output)
+
+	corrupt(t, exePath, "a.txt")
+	for _, args := range [][]string{
+		{"verify", "-exe", exePath},
+		{"verify", "-exe", exePath, "-public-key", key + ".pub"},
+	} {
+		var err error
+		output := captureStdout(t, func() { err = run(args) })
+		assert.EqualError(t, err, "1 of 2 attachments failed verification", args)
+		assert.Contains(t, output, "FAILED\ta.txt: ", args)
+		assert.Contains(t, output, "OK\tb.json\n", "every attachment is reported")
+	}
+
+	other := filepath.Join(dir, "other")
+	assert.NoError(t, run([]string{"keygen", "-out", other}))
+	err = run([]string{"verify", "-exe", exePath, "-public-key", other + ".pub"})
+	assert.ErrorIs(t, err, ember.ErrInvalidSignature)
+}
+
+func Test_safePath(t *testing.T) {
+	tests := map[string]string{
+		"file":          "file",
+		"sub/file":      "sub/file",
+		`sub\file`:      "sub/file",
+		"../escape":     "",
+		"sub/../../esc": "",
+		"/absolute":     "",
+		`\absolute`:     "",
+		`C:\drive`:      "",
+		"c:drive":       "",
+		".":             "",
+		"":              "",
+	}
+	for name, expected := range tests {
+		p, err := safePath(name)
+		if expected == "" {
+			assert.ErrorIs(t, err, ember.ErrUnsafeName, name)
+		} else {
+			assert.NoError(t, err, name)
+			assert.Equal(t, expected, p, name)
+		}
+	}
+}
+
+func Test_splitPairs(t *testing.T) {
+	pairs, err := splitPairs("-add", []string{"a=path/a", "b=x=y", "c=", "=d"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"a": "path/a",
+		"b": "x=y",
+		"c": "",
+		"":  "d",
+	}, pairs)
+
+	pairs, err = splitPairs("-add", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, pairs)
+
+	_, err = splitPairs("-add", []string{"a=b", "invalid"})
+	assert.EqualError(t, err, `Invalid value "invalid" for -add (expected name=value)`)
+}
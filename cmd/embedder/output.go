@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// backupSuffix is appended to the original executable when keeping a backup during in-place modifications.
+const backupSuffix = ".bak"
+
+// outputFlags select where the resulting executable is written.
+type outputFlags struct {
+	out     string
+	inplace bool
+	backup  bool
+}
+
+func (o *outputFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.out, "out", "", "Path for the resulting executable. Must not exist yet")
+	flags.BoolVar(&o.inplace, "inplace", false, "Modify the executable in-place instead of writing to -out. The original is replaced atomically")
+	flags.BoolVar(&o.backup, "backup", false, "Keep the original executable with the suffix '"+backupSuffix+"' (requires -inplace)")
+}
+
+// check ensures that exactly one output was selected. Prints the command's usage and returns errUsage otherwise.
+func (o *outputFlags) check(flags *flag.FlagSet) error {
+	switch {
+	case o.out == "" && !o.inplace:
+		return usageErr(flags, "Missing required flag -out (or -inplace)")
+	case o.out != "" && o.inplace:
+		return usageErr(flags, "The flags -out and -inplace are mutually exclusive")
+	case o.backup && !o.inplace:
+		return usageErr(flags, "The flag -backup requires -inplace")
+	}
+	return nil
+}
+
+// args returns the arguments for passing the output to another command.
+func (o *outputFlags) args() []string {
+	if !o.inplace {
+		return []string{"-out", o.out}
+	}
+	if o.backup {
+		return []string{"-inplace", "-backup"}
+	}
+	return []string{"-inplace"}
+}
+
+// target returns the path of the resulting executable.
+func (o *outputFlags) target(exePath string) string {
+	if o.inplace {
+		return exePath
+	}
+	return o.out
+}
+
+// write creates the resulting executable, using the content produced by the write function.
+// The source executable (exe, located at exePath) is closed afterwards.
+// Its file mode is applied to the result.
+//
+// Without -inplace, the output file must not exist yet. It is removed if writing fails.
+func (o *outputFlags) write(exePath string, exe *os.File, write func(out io.Writer) error) error {
+	defer exe.Close()
+	stat, err := exe.Stat()
+	if err != nil {
+		return err
+	}
+	if o.inplace {
+		return writeInPlace(exePath, exe, stat, o.backup, write)
+	}
+
+	out, err := os.OpenFile(o.out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = write(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(o.out)
+	}
+	return err
+}
+
+// writeInPlace replaces the executable at exePath with the content produced by the write function.
+//
+// The content is written to a temporary file in the same directory, which is synced to disk
+// and receives the mode, ownership and extended attributes of the original (as far as permitted).
+// Afterwards, the temporary file is renamed over the original, so readers either see the old or the new executable.
+// If exePath is a symbolic link, its target is replaced.
+func writeInPlace(exePath string, exe *os.File, stat fs.FileInfo, backup bool, write func(out io.Writer) error) (err error) {
+	path, err := filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	// ownership is applied first, as changing it can clear the setuid and setgid bits
+	if err := copyAttributes(exe, tmp, stat); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(stat.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the source must be closed before it can be replaced on windows
+	if err := exe.Close(); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := createBackup(path, path+backupSuffix); err != nil {
+			return fmt.Errorf("create backup: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// createBackup preserves the file at path as backup, replacing previous backups.
+// The backup is a hard link if possible, and a copy otherwise.
+func createBackup(path, backup string) error {
+	if err := os.Remove(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, backup); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(backup, os.O_CREATE|os.O_EXCL|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if syncErr := dst.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(backup)
+	}
+	return err
+}
+
+// syncDir flushes a directory to disk, persisting renames within it.
+// Errors are ignored, as not all platforms support syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeOutput writes content to the output selected by o, using exePath as source.
+func writeOutput(t *testing.T, o *outputFlags, exePath string, write func(out io.Writer) error) error {
+	exe, err := os.Open(exePath)
+	if !assert.NoError(t, err) {
+		return err
+	}
+	return o.write(exePath, exe, write)
+}
+
+// writeString returns a write function writing the given content.
+func writeString(content string) func(out io.Writer) error {
+	return func(out io.Writer) error {
+		_, err := io.WriteString(out, content)
+		return err
+	}
+}
+
+// assertContent ensures that the file at path contains the given content.
+func assertContent(t *testing.T, path, content string) {
+	data, err := os.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, content, string(data))
+	}
+}
+
+// assertDirEntries ensures that the directory contains exactly the given files.
+func assertDirEntries(t *testing.T, dir string, names ...string) {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	var actual []string
+	for _, e := range entries {
+		actual = append(actual, e.Name())
+	}
+	assert.ElementsMatch(t, names, actual)
+}
+
+func TestWriteInPlace(t *testing.T) {
+	dir := t.TempDir()
+	exePath := filepath.Join(dir, "app")
+	assert.NoError(t, os.WriteFile(exePath, []byte("original"), 0750))
+	assert.NoError(t, os.Chmod(exePath, 0750)) // not affected by umask
+
+	err := writeOutput(t, &outputFlags{inplace: true}, exePath, writeString("modified"))
+	assert.NoError(t, err)
+	assertContent(t, exePath, "modified")
+	stat, err := os.Stat(exePath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fs.FileMode(0750), stat.Mode())
+	}
+	assertDirEntries(t, dir, "app") // no temporary files are left behind
+}
+
+func TestWriteInPlace_backup(t *testing.T) {
+	dir := t.TempDir()
+	exePath := filepath.Join(dir, "app")
+	assert.NoError(t, os.WriteFile(exePath, []byte("original"), 0755))
+	assert.NoError(t, os.WriteFile(exePath+backupSuffix, []byte("previous backup"), 0644))
+
+	err := writeOutput(t, &outputFlags{inplace: true, backup: true}, exePath, writeString("modified"))
+	assert.NoError(t, err)
+	assertContent(t, exePath, "modified")
+	assertContent(t, exePath+backupSuffix, "original")
+	assertDirEntries(t, dir, "app", "app"+backupSuffix)
+}
+
+func TestWriteInPlace_symlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "app")
+	link := filepath.Join(dir, "link")
+	assert.NoError(t, os.WriteFile(target, []byte("original"), 0755))
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symbolic links are not supported: %s", err)
+	}
+
+	err := writeOutput(t, &outputFlags{inplace: true}, link, writeString("modified"))
+	assert.NoError(t, err)
+	assertContent(t, target, "modified")
+	stat, err := os.Lstat(link)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fs.ModeSymlink, stat.Mode().Type(), "the link is preserved")
+	}
+}
+
+func TestWriteInPlace_failure(t *testing.T) {
+	dir := t.TempDir()
+	exePath := filepath.Join(dir, "app")
+	assert.NoError(t, os.WriteFile(exePath, []byte("original"), 0755))
+
+	err := writeOutput(t, &outputFlags{inplace: true, backup: true}, exePath, func(out io.Writer) error {
+		_, _ = io.WriteString(out, "partial")
+		return errors.New("simulated error")
+	})
+	assert.EqualError(t, err, "simulated error")
+	assertContent(t, exePath, "original")
+	assertDirEntries(t, dir, "app") // neither temporary files, nor a backup
+}
+
+func TestOutputFlags_write(t *testing.T) {
+	dir := t.TempDir()
+	exePath := filepath.Join(dir, "app")
+	assert.NoError(t, os.WriteFile(exePath, []byte("original"), 0755))
+	out := filepath.Join(dir, "out")
+
+	err := writeOutput(t, &outputFlags{out: out}, exePath, func(out io.Writer) error {
+		_, _ = io.WriteString(out, "partial")
+		return errors.New("simulated error")
+	})
+	assert.EqualError(t, err, "simulated error")
+	assert.NoFileExists(t, out, "incomplete output is removed")
+
+	err = writeOutput(t, &outputFlags{out: out}, exePath, writeString("modified"))
+	assert.NoError(t, err)
+	assertContent(t, out, "modified")
+	assertContent(t, exePath, "original")
+
+	err = writeOutput(t, &outputFlags{out: out}, exePath, writeString("again"))
+	assert.ErrorIs(t, err, fs.ErrExist, "existing files are not overwritten")
+	assertContent(t, out, "modified")
+}
+
+func Test_createBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app")
+	backup := path + backupSuffix
+	assert.NoError(t, os.WriteFile(path, []byte("original"), 0755))
+	assert.NoError(t, os.WriteFile(backup, []byte("previous backup"), 0644))
+
+	assert.NoError(t, createBackup(path, backup))
+	assertContent(t, backup, "original")
+	assertContent(t, path, "original")
+}
@@ -0,0 +1,13 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyOwner is a no-op, as file ownership is not supported on this platform.
+func copyOwner(*os.File, fs.FileInfo) error {
+	return nil
+}
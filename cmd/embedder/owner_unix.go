@@ -0,0 +1,22 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner applies the owner and group of stat to dst, if permitted.
+func copyOwner(dst *os.File, stat fs.FileInfo) error {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := dst.Chown(int(sys.Uid), int(sys.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package ember
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrNoCodec is returned when decoding an attachment whose format is unknown.
+var ErrNoCodec = errors.New("no codec for attachment format")
+
+// Codec decodes attachments of a specific format, like JSON or YAML.
+type Codec interface {
+	// Decode decodes the data read from r into v, which is a non-nil pointer.
+	// If strict is set, unknown fields must be rejected.
+	Decode(r io.Reader, v interface{}, strict bool) error
+}
+
+// CodecFunc is an adapter to allow the use of ordinary functions as Codec.
+type CodecFunc func(r io.Reader, v interface{}, strict bool) error
+
+// Decode calls f(r, v, strict).
+func (f CodecFunc) Decode(r io.Reader, v interface{}, strict bool) error {
+	return f(r, v, strict)
+}
+
+// JSON decodes JSON documents using encoding/json.
+// It is registered for the ".json" extension and the "application/json" content type.
+var JSON Codec = CodecFunc(func(r io.Reader, v interface{}, strict bool) error {
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+})
+
+// codec registry
+var (
+	codecsMu       sync.RWMutex
+	extCodecs      = map[string]Codec{".json": JSON}
+	mimeTypeCodecs = map[string]Codec{"application/json": JSON}
+)
+
+// RegisterExtension registers the codec for decoding attachments with the given file extension (eg. ".yaml").
+// Extensions are case-insensitive.
+func RegisterExtension(ext string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	extCodecs[strings.ToLower(ext)] = codec
+}
+
+// RegisterContentType registers the codec for decoding attachments with the given content type (eg. "application/yaml").
+// The content type takes precedence over the file extension.
+func RegisterContentType(contentType string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	mimeTypeCodecs[strings.ToLower(contentType)] = codec
+}
+
+// lookupCodec returns the registered codec for the given attachment.
+// Returns nil if there is none.
+func (a *Attachments) lookupCodec(name string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	if mediaType, _, err := mime.ParseMediaType(a.ContentType(name)); err == nil {
+		if codec, ok := mimeTypeCodecs[mediaType]; ok {
+			return codec
+		}
+	}
+	return extCodecs[strings.ToLower(path.Ext(name))]
+}
+
+// DecodeOption configures how attachments are decoded.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	strict bool
+}
+
+// Strict rejects documents containing fields that do not exist in the target type.
+func Strict() DecodeOption {
+	return func(o *decodeOptions) {
+		o.strict = true
+	}
+}
+
+// Decode decodes an attachment into a value of type T.
+// If codec is nil, it is chosen based on the stored content type and the file extension
+// (see RegisterContentType and RegisterExtension).
+//
+// Returns ErrNotFound if no attachment with that name exists, and ErrNoCodec if the format is unknown.
+func Decode[T any](att *Attachments, name string, codec Codec, opts ...DecodeOption) (T, error) {
+	var value T
+
+	o := &decodeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	e, ok := att.entry(name)
+	if !ok {
+		return value, &AttErr{Path: att.path, Name: name, Offset: -1, Err: ErrNotFound}
+	}
+	if codec == nil {
+		if codec = att.lookupCodec(name); codec == nil {
+			return value, att.attErr(fmt.Errorf("%w (content type %q)", ErrNoCodec, att.ContentType(name)), e)
+		}
+	}
+	if err := codec.Decode(att.Reader(name), &value, o.strict); err != nil {
+		return value, att.attErr(err, e)
+	}
+	return value, nil
+}
@@ -0,0 +1,139 @@
+package ember
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// lineCodec decodes "key=value" lines into a testConfig.
+var lineCodec = CodecFunc(func(r io.Reader, v interface{}, strict bool) error {
+	cfg := v.(*testConfig)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, _ := strings.Cut(s.Text(), "=")
+		switch key {
+		case "host":
+			cfg.Host = value
+		default:
+			if strict {
+				return errors.New("unknown field " + key)
+			}
+		}
+	}
+	return s.Err()
+})
+
+func TestDecode(t *testing.T) {
+	files := map[string]string{
+		"config.json":  `{"host":"localhost","port":8080}`,
+		"unknown.json": `{"host":"localhost","user":"admin"}`,
+		"invalid.json": `{"host":`,
+		"config.txt":   "host=example.com",
+		"config.lines": "host=example.com\nuser=admin",
+		"typed":        "host=typed.com",
+	}
+	var toc internal.TOC
+	var data [][]byte
+	for name, content := range files {
+		a := internal.Attachment{Name: name, Size: int64(len(content))}
+		if name == "typed" {
+			a.ContentType = "text/x-lines; charset=utf-8"
+		}
+		toc = append(toc, a)
+		data = append(data, []byte(content))
+	}
+	path := prepareFile(t, toc, data)
+	defer os.Remove(path)
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	restoreCodecs(t)
+	RegisterExtension(".LINES", lineCodec)
+	RegisterContentType("text/x-lines", lineCodec)
+
+	cfg, err := Decode[testConfig](att, "config.json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testConfig{Host: "localhost", Port: 8080}, cfg)
+
+	ptr, err := Decode[*testConfig](att, "config.json", JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, &testConfig{Host: "localhost", Port: 8080}, ptr)
+
+	t.Run("strict", func(t *testing.T) {
+		cfg, err := Decode[testConfig](att, "unknown.json", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost", cfg.Host)
+
+		_, err = Decode[testConfig](att, "unknown.json", nil, Strict())
+		assert.ErrorContains(t, err, `attachment "unknown.json"`)
+		assert.ErrorContains(t, err, "unknown field")
+
+		_, err = Decode[testConfig](att, "config.lines", nil, Strict())
+		assert.ErrorContains(t, err, "unknown field user")
+	})
+
+	t.Run("codec selection", func(t *testing.T) {
+		cfg, err := Decode[testConfig](att, "config.lines", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "example.com", cfg.Host)
+
+		cfg, err = Decode[testConfig](att, "typed", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "typed.com", cfg.Host)
+
+		cfg, err = Decode[testConfig](att, "config.txt", lineCodec)
+		assert.NoError(t, err)
+		assert.Equal(t, "example.com", cfg.Host)
+
+		_, err = Decode[testConfig](att, "config.txt", nil)
+		assert.ErrorIs(t, err, ErrNoCodec)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := Decode[testConfig](att, "missing.json", nil)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = Decode[testConfig](att, "invalid.json", nil)
+		var attErr *AttErr
+		if assert.ErrorAs(t, err, &attErr) {
+			assert.Equal(t, "invalid.json", attErr.Name)
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+	})
+}
+
+// restoreCodecs restores the codec registry once the test finished.
+func restoreCodecs(t *testing.T) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	ext := copyCodecs(extCodecs)
+	mimeTypes := copyCodecs(mimeTypeCodecs)
+
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		defer codecsMu.Unlock()
+		extCodecs = ext
+		mimeTypeCodecs = mimeTypes
+	})
+}
+
+func copyCodecs(m map[string]Codec) map[string]Codec {
+	c := make(map[string]Codec, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
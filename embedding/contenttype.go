@@ -0,0 +1,46 @@
+package embedding
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// contentTypes maps file extensions to the content types stored by EmbedFiles.
+// A fixed table is used instead of the mime package, whose types depend on the system
+// and would make the output differ between build machines.
+var contentTypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".gif":   "image/gif",
+	".gz":    "application/gzip",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/vnd.microsoft.icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".md":    "text/markdown; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".toml":  "application/toml",
+	".ttf":   "font/ttf",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+	".yaml":  "application/yaml",
+	".yml":   "application/yaml",
+	".zip":   "application/zip",
+}
+
+// contentTypeOf returns the content type for the extension of the given path or name.
+// Returns an empty string if the extension is unknown.
+func contentTypeOf(path string) string {
+	return contentTypes[strings.ToLower(filepath.Ext(path))]
+}
@@ -0,0 +1,14 @@
+package embedding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_contentTypeOf(t *testing.T) {
+	assert.Equal(t, "application/json", contentTypeOf("config.json"))
+	assert.Equal(t, "text/html; charset=utf-8", contentTypeOf("web/INDEX.HTML"))
+	assert.Equal(t, "", contentTypeOf("data.bin"), "unknown extensions are not looked up in the system's MIME types")
+	assert.Equal(t, "", contentTypeOf("README"))
+}
@@ -1,12 +1,16 @@
 package embedding
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/maja42/ember/internal"
 )
@@ -35,29 +39,99 @@ type PrintlnFunc func(format string, args ...interface{})
 //
 // logger (optional) is used to report the progress during embedding.
 //
+// opts (optional) configure additional features, like signing.
+//
+// The output is reproducible: Embedding the same content with the same options always produces identical bytes.
+// Attachments are stored in the order of their names. This includes encrypted attachments,
+// so it can be seen whether the content of an encrypted attachment changed between two executables.
+//
 // Note that all ReadSeekers are seeked to their start before usage,
 // meaning the entirety of readable content is embedded. Use io.SectionReader to avoid this.
-func Embed(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeker, logger PrintlnFunc) error {
-	if logger == nil {
-		logger = func(string, ...interface{}) {}
+func Embed(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeker, logger PrintlnFunc, opts ...Option) error {
+	cfg := applyOptions(opts)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if len(cfg.tombstones) > 0 {
+		return errors.New("tombstones can only be added to existing attachments")
 	}
-
 	if err := verifyTargetExe(exe, SkipCompatibilityCheck); err != nil {
 		return fmt.Errorf("verify executable: %w", err)
 	}
+	return embed(out, exe, nil, attachments, nil, logger, cfg)
+}
 
-	toc, err := buildTOC(attachments)
+// EmbedLayer appends a new layer of attachments to an executable that already contains attachments.
+//
+// The existing attachments are preserved. Attachments of the new layer override existing ones with the same name,
+// and existing attachments can be removed via WithTombstones.
+// The application sees the merged view of all layers.
+//
+// Returns ErrNothingEmbedded if the executable does not contain attachments yet.
+// Layers can only be appended to executables whose attachments end with a footer,
+// which is written by all recent versions of ember.
+//
+// The signature of the new layer (see WithSigningKey) covers the TOC of the previous layer.
+// Layers can therefore not be removed from the middle or moved onto other attachments without invalidating it.
+// Note that removing trailing layers (like a layer containing a fix or tombstone) can not be detected,
+// as the remaining layers stay valid.
+//
+// See Embed for more information.
+func EmbedLayer(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeker, logger PrintlnFunc, opts ...Option) error {
+	cfg := applyOptions(opts)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	for _, name := range cfg.tombstones {
+		if _, ok := attachments[name]; ok {
+			return fmt.Errorf("attachment %q: cannot be added and removed at the same time", name)
+		}
+	}
+	prevTOC, err := verifyLayerTarget(exe)
+	if err != nil {
+		return fmt.Errorf("verify executable: %w", err)
+	}
+	return embed(out, exe, prevTOC, attachments, nil, logger, cfg)
+}
+
+// storedAttachment is an attachment of an existing executable, which is copied without modification.
+type storedAttachment struct {
+	internal.Attachment
+	data *io.SectionReader // stored (compressed and encrypted) data
+}
+
+// embed copies the executable and appends a new layer containing the attachments.
+// Stored attachments are copied as-is.
+// prevTOC is the serialized TOC of the last layer within exe, or nil if exe contains no attachments.
+func embed(out io.Writer, exe io.Reader, prevTOC []byte, attachments map[string]io.ReadSeeker, stored map[string]storedAttachment, logger PrintlnFunc, cfg *config) error {
+	if logger == nil {
+		logger = func(string, ...interface{}) {}
+	}
+
+	toc, err := buildTOC(attachments, cfg)
 	if err != nil {
 		return fmt.Errorf("build TOC: %w", err)
 	}
-	jsonTOC, err := json.Marshal(toc)
+	if len(stored) > 0 {
+		for _, s := range stored {
+			toc = append(toc, s.Attachment)
+		}
+		sort.Slice(toc, func(i, j int) bool {
+			return toc[i].Name < toc[j].Name
+		})
+	}
+	for _, name := range cfg.tombstones {
+		toc = append(toc, internal.Attachment{Name: name, Tombstone: true})
+	}
+	tocData, err := encodeTOC(toc, cfg.legacyTOC)
 	if err != nil {
-		return fmt.Errorf("marshal TOC: %w", err)
+		return fmt.Errorf("encode TOC: %w", err)
 	}
 
 	// Executable
 	logger("Writing executable")
-	if _, err := io.Copy(out, exe); err != nil {
+	exeSize, err := io.Copy(out, exe)
+	if err != nil {
 		return fmt.Errorf("copy executable: %w", err)
 	}
 	// Boundary
@@ -65,8 +139,8 @@ func Embed(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeke
 		return err
 	}
 	// TOC
-	logger("Adding TOC (%d bytes)", len(jsonTOC))
-	if _, err := out.Write(jsonTOC); err != nil {
+	logger("Adding TOC (%d bytes)", len(tocData))
+	if _, err := out.Write(tocData); err != nil {
 		return fmt.Errorf("write TOC: %w", err)
 	}
 	// Boundary
@@ -75,8 +149,19 @@ func Embed(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeke
 	}
 	// Attachments
 	for _, att := range toc {
-		logger("Adding %q (%d bytes)", att.Name, att.Size)
-		if _, err := io.Copy(out, attachments[att.Name]); err != nil {
+		if att.Tombstone {
+			logger("Removing %q", att.Name)
+			continue
+		}
+		if s, ok := stored[att.Name]; ok {
+			logger("Copying %q (%s)", att.Name, describe(att))
+			if err := copyStored(out, s); err != nil {
+				return fmt.Errorf("copy attachment %q: %w", att.Name, err)
+			}
+			continue
+		}
+		logger("Adding %q (%s)", att.Name, describe(att))
+		if err := writeAttachment(out, attachments[att.Name], att, cfg.keyFor(att.Name)); err != nil {
 			return fmt.Errorf("write attachment %q: %w", att.Name, err)
 		}
 	}
@@ -84,18 +169,89 @@ func Embed(out io.Writer, exe io.ReadSeeker, attachments map[string]io.ReadSeeke
 	if err := internal.WriteBoundary(out); err != nil {
 		return err
 	}
+	// Signature
+	if cfg.signingKey != nil {
+		logger("Signing TOC")
+		if err := internal.WriteSignature(out, internal.Sign(cfg.signingKey, tocData, prevTOC)); err != nil {
+			return fmt.Errorf("write signature: %w", err)
+		}
+	}
+	// Footer
+	footer := internal.Footer{
+		TOCOffset: exeSize + int64(internal.BoundarySize),
+		TOCSize:   int64(len(tocData)),
+		Version:   internal.FooterVersion,
+	}
+	if err := internal.WriteFooter(out, footer); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
 	return nil
 }
 
+// encodeTOC serializes the TOC in the binary format, or as JSON for older versions of ember.
+func encodeTOC(toc internal.TOC, legacy bool) ([]byte, error) {
+	if legacy {
+		return json.Marshal(toc)
+	}
+	return internal.EncodeTOC(toc)
+}
+
 // EmbedFiles embeds the given files into the target executable.
 //
 // attachments is a map of attachment names to the respective file's filepath.
+// The path StdinPath ("-") reads the attachment from standard input instead, which is buffered like in EmbedReaders.
+//
+// The file mode and content type (based on the file extension) are stored as metadata.
+// Modification times are only stored if requested via WithFileModTimes.
+// They can be overridden via WithMetadata.
 //
 // See Embed for more information.
-func EmbedFiles(out io.Writer, exe io.ReadSeeker, attachments map[string]string, logger PrintlnFunc) error {
+func EmbedFiles(out io.Writer, exe io.ReadSeeker, attachments map[string]string, logger PrintlnFunc, opts ...Option) error {
+	return withFiles(attachments, opts, func(reader map[string]io.ReadSeeker, opts []Option) error {
+		return Embed(out, exe, reader, logger, opts...)
+	})
+}
+
+// EmbedLayerFiles appends a new layer with the given files to an executable that already contains attachments.
+//
+// See EmbedFiles and EmbedLayer for more information.
+func EmbedLayerFiles(out io.Writer, exe io.ReadSeeker, attachments map[string]string, logger PrintlnFunc, opts ...Option) error {
+	return withFiles(attachments, opts, func(reader map[string]io.ReadSeeker, opts []Option) error {
+		return EmbedLayer(out, exe, reader, logger, opts...)
+	})
+}
+
+// StdinPath can be used as file path for EmbedFiles, to embed the content of standard input (which may be a pipe).
+const StdinPath = "-"
+
+// withFiles opens the given files and passes them to fn, together with options for storing their metadata.
+// The files are closed afterwards.
+func withFiles(attachments map[string]string, opts []Option, fn func(map[string]io.ReadSeeker, []Option) error) error {
 	reader := make(map[string]io.ReadSeeker, len(attachments))
+	fileOpts := make([]Option, 0, len(attachments)+len(opts))
+	cfg := applyOptions(opts)
+
+	s := newSpooler(cfg)
+	defer s.close()
+	stdinUsed := false
 
 	for name, path := range attachments {
+		if path == StdinPath {
+			if stdinUsed {
+				return fmt.Errorf("attachment %q: standard input can only be embedded once", name)
+			}
+			stdinUsed = true
+			r, err := s.spool(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("read attachment %q from standard input: %w", name, err)
+			}
+			reader[name] = r
+			fileOpts = append(fileOpts, WithMetadata(name, Metadata{
+				ContentType: contentTypeOf(name),
+			}))
+			continue
+		}
+
 		file, err := os.Open(path)
 		if err != nil {
 			return fmt.Errorf("open attachment %q (%q): %w", name, path, err)
@@ -103,8 +259,23 @@ func EmbedFiles(out io.Writer, exe io.ReadSeeker, attachments map[string]string,
 		//goland:noinspection ALL
 		defer file.Close()
 		reader[name] = file
+
+		stat, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("stat attachment %q (%q): %w", name, path, err)
+		}
+		meta := Metadata{
+			Mode:        stat.Mode(),
+			ContentType: contentTypeOf(path),
+		}
+		if cfg.fileModTimes {
+			meta.ModTime = stat.ModTime()
+		}
+		fileOpts = append(fileOpts, WithMetadata(name, meta))
 	}
-	return Embed(out, exe, reader, logger)
+	// explicit options take precedence
+	fileOpts = append(fileOpts, opts...)
+	return fn(reader, fileOpts)
 }
 
 // verifyTargetExe ensures that the target executable is compatible.
@@ -123,34 +294,197 @@ func verifyTargetExe(exe io.ReadSeeker, skipCompatibilityCheck bool) error {
 }
 
 // buildTOC returns the TOC (table-of-contents) for embedding the given data.
+// Attachments are sorted by name, so the output does not depend on map iteration order.
 // All attachments are seeked to the beginning afterwards.
-func buildTOC(attachments map[string]io.ReadSeeker) (internal.TOC, error) {
-	toc := make(internal.TOC, 0, len(attachments))
+func buildTOC(attachments map[string]io.ReadSeeker, cfg *config) (internal.TOC, error) {
+	epoch, err := sourceDateEpoch()
+	if err != nil {
+		return nil, err
+	}
 
-	for name, r := range attachments {
-		size, err := getSize(r)
-		if err != nil {
+	toc := make(internal.TOC, 0, len(attachments))
+	for _, name := range sortedKeys(attachments) {
+		r := attachments[name]
+		att := internal.Attachment{
+			Name:        name,
+			Compression: cfg.compressionFor(name),
+		}
+		cfg.metadata[name].apply(&att)
+		if epoch != nil && att.ModTime > epoch.UnixNano() {
+			att.ModTime = epoch.UnixNano()
+		}
+		if key := cfg.encryptionFor(name); key != nil {
+			att.Encryption = internal.EncryptionAES256GCM
+			att.KeyID = key.id
+			if att.Salt, err = deriveSalt(r, name, att.Compression, key.key); err != nil {
+				return nil, fmt.Errorf("attachment %q: %w", name, err)
+			}
+		}
+		if err := measure(r, &att, cfg.keyFor(name)); err != nil {
 			return nil, fmt.Errorf("attachment %q: %w", name, err)
 		}
-		toc = append(toc, internal.Attachment{
-			Name: name,
-			Size: size,
-		})
+		toc = append(toc, att)
 	}
 	return toc, nil
 }
 
-// getSize returns the size of the readable content.
-// The reader is seeked to the beginning afterwards.
-func getSize(r io.ReadSeeker) (int64, error) {
-	size, err := r.Seek(0, io.SeekEnd)
+// SourceDateEpochEnv is the environment variable for reproducible builds (see https://reproducible-builds.org).
+// If set, stored modification times are clamped to it.
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// sourceDateEpoch returns the time specified via SourceDateEpochEnv, or nil if unset.
+func sourceDateEpoch() (*time.Time, error) {
+	value := os.Getenv(SourceDateEpochEnv)
+	if value == "" {
+		return nil, nil
+	}
+	sec, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("invalid %s %q", SourceDateEpochEnv, value)
 	}
+	t := time.Unix(sec, 0)
+	return &t, nil
+}
+
+// deriveSalt derives the salt of an encrypted attachment from its compressed content, so that encryption is reproducible.
+// Salts depend on the data that is actually encrypted: the same content stored with another compression gets another salt.
+// The reader is seeked to the beginning before and afterwards.
+func deriveSalt(r io.ReadSeeker, name, compression string, key []byte) ([]byte, error) {
 	if _, err := r.Seek(0, io.SeekStart); err != nil {
-		return 0, err
+		return nil, err
+	}
+	h := sha256.New()
+	compressor, err := internal.NewCompressor(compression, h)
+	if err != nil {
+		return nil, err
 	}
-	return size, nil
+	if _, err := io.Copy(compressor, r); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return internal.DeriveSalt(key, name, compression, h.Sum(nil)), nil
+}
+
+// measure determines the size, the stored (compressed and encrypted) size and the digest of the readable content
+// and stores them in the TOC entry.
+// The reader is seeked to the beginning before and afterwards.
+func measure(r io.ReadSeeker, att *internal.Attachment, key []byte) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := internal.NewDigest(att.Encryption, key, att.Salt)
+	var compressed countingWriter
+	compressor, err := internal.NewCompressor(att.Compression, &compressed)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(io.MultiWriter(h, compressor), r)
+	if err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	stored := compressed.n
+	if att.Encryption != internal.EncryptionNone {
+		stored = internal.EncryptedSize(stored)
+	}
+	att.Size = size
+	att.Digest = h.Sum(nil)
+	if stored != size {
+		att.StoredSize = stored
+	}
+	return nil
+}
+
+// copyStored copies the stored data of an existing attachment.
+func copyStored(out io.Writer, s storedAttachment) error {
+	n, err := io.Copy(out, s.data)
+	if err != nil {
+		return err
+	}
+	if n != s.Stored() {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// writeAttachment writes the (compressed and encrypted) attachment data.
+// Fails if the written data does not match the TOC entry, which happens if the content changed since building the TOC.
+func writeAttachment(out io.Writer, r io.Reader, att internal.Attachment, key []byte) error {
+	stored := countingWriter{w: out}
+
+	var w io.Writer = &stored
+	var encryptor io.WriteCloser
+	if att.Encryption != internal.EncryptionNone {
+		var err error
+		if encryptor, err = internal.NewEncryptor(&stored, key, att.Salt); err != nil {
+			return err
+		}
+		w = encryptor
+	}
+	compressor, err := internal.NewCompressor(att.Compression, w)
+	if err != nil {
+		return err
+	}
+
+	size, err := io.Copy(compressor, r)
+	if err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if encryptor != nil {
+		if err := encryptor.Close(); err != nil {
+			return err
+		}
+	}
+	if size != att.Size || stored.n != att.Stored() {
+		return errors.New("content changed during embedding")
+	}
+	return nil
+}
+
+// describe returns a human-readable description of how an attachment is stored.
+func describe(att internal.Attachment) string {
+	desc := fmt.Sprintf("%d bytes", att.Size)
+	if att.Compression != internal.CompressionNone {
+		desc += ", " + att.Compression + "-compressed"
+	}
+	if att.Encryption != internal.EncryptionNone {
+		desc += ", encrypted"
+	}
+	if att.Stored() != att.Size {
+		desc += fmt.Sprintf(", %d bytes stored", att.Stored())
+	}
+	return desc
+}
+
+// countingWriter counts the number of bytes written.
+// Data is forwarded to w, if set.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.w == nil {
+		c.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // ErrAlreadyEmbedded is returned if the target executable already contains attachments.
@@ -196,6 +530,47 @@ func verifyCompatibility(exe io.ReadSeeker, marker string) error {
 // ErrNothingEmbedded is returned if the executable does not contain any attachments.
 var ErrNothingEmbedded = errors.New("contains no embedded data")
 
+// verifyLayerTarget ensures that the executable contains attachments that end with a footer,
+// so a new layer can be appended. Returns the serialized TOC of the last layer.
+// The reader is seeked to the beginning afterwards.
+func verifyLayerTarget(exe io.ReadSeeker) ([]byte, error) {
+	size, err := exe.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size >= internal.FooterSize {
+		if _, err := exe.Seek(size-internal.FooterSize, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var data = make([]byte, internal.FooterSize)
+		if _, err := io.ReadFull(exe, data); err != nil {
+			return nil, err
+		}
+		if footer, ok := internal.ParseFooter(data); ok {
+			if footer.TOCSize < 0 || footer.TOCOffset < 0 || footer.TOCOffset+footer.TOCSize > size {
+				return nil, errors.New("invalid footer")
+			}
+			toc := make([]byte, footer.TOCSize)
+			if _, err := exe.Seek(footer.TOCOffset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			if _, err := io.ReadFull(exe, toc); err != nil {
+				return nil, err
+			}
+			_, err := exe.Seek(0, io.SeekStart)
+			return toc, err
+		}
+	}
+
+	if _, err := exe.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if internal.SeekBoundary(exe) == -1 {
+		return nil, ErrNothingEmbedded
+	}
+	return nil, errors.New("existing attachments have no footer (embedded by an older version, or followed by other data)")
+}
+
 // RemoveEmbedding removes any data embedded with ember from the executable.
 // Returns ErrNothingEmbedded if the executable contains no embedded data.
 //
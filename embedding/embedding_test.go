@@ -2,11 +2,18 @@ package embedding
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/maja42/ember"
 	"github.com/maja42/ember/internal"
@@ -52,10 +59,306 @@ func TestEmbed(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("second content"), content)
 
+	assert.NoError(t, att.VerifyAll())
+
 	err = att.Close()
 	assert.NoError(t, err)
 }
 
+func TestEmbed_footer(t *testing.T) {
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil)
+	assert.NoError(t, err)
+
+	data := out.Bytes()
+	footer, ok := internal.ParseFooter(data[len(data)-internal.FooterSize:])
+	assert.True(t, ok)
+	assert.Equal(t, uint16(internal.FooterVersion), footer.Version)
+	assert.Equal(t, int64(len(exe)+internal.BoundarySize), footer.TOCOffset)
+
+	toc := data[footer.TOCOffset : footer.TOCOffset+footer.TOCSize]
+	assert.True(t, internal.IsBinaryTOC(toc))
+	index, err := internal.ParseTOC(toc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index.Len())
+	a, offset := index.Entry(0)
+	assert.Equal(t, "att", a.Name)
+	assert.Equal(t, int64(0), offset)
+	assert.Equal(t, int64(7), index.DataSize())
+}
+
+func TestEmbed_legacyTOC(t *testing.T) {
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil, WithLegacyTOC())
+	assert.NoError(t, err)
+
+	data := out.Bytes()
+	footer, ok := internal.ParseFooter(data[len(data)-internal.FooterSize:])
+	assert.True(t, ok)
+	toc := data[footer.TOCOffset : footer.TOCOffset+footer.TOCSize]
+	assert.Equal(t, `[{"Name":"att","Size":7,"Digest":"7XACtDnprIRfIjV9giusFERzD722AW0+yUMil7nsn3M="}]`, string(toc))
+
+	path := writeTempFile(t, data)
+	att, err := ember.OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+	content, err := io.ReadAll(att.Reader("att"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestEmbed_compressed(t *testing.T) {
+	content := strings.Repeat("compressible content ", 100)
+
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"plain": strings.NewReader(content),
+		"gzip":  strings.NewReader(content),
+		"zstd":  strings.NewReader(content),
+		"empty": strings.NewReader(""),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil,
+		WithCompression(CompressionZstd),
+		WithCompression(CompressionGzip, "gzip"),
+		WithCompression(CompressionNone, "plain"),
+	)
+	assert.NoError(t, err)
+	path := writeTempFile(t, out.Bytes())
+
+	att, err := ember.OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	for _, name := range []string{"plain", "gzip", "zstd"} {
+		assert.Equal(t, int64(len(content)), att.Size(name))
+
+		data, err := io.ReadAll(att.Reader(name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+	assert.Equal(t, int64(len(content)), att.StoredSize("plain"))
+	assert.Less(t, att.StoredSize("gzip"), int64(len(content)))
+	assert.Less(t, att.StoredSize("zstd"), int64(len(content)))
+
+	data, err := io.ReadAll(att.Reader("empty"))
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	assert.NoError(t, att.VerifyAll())
+	assert.NoError(t, fstest.TestFS(att, "plain", "gzip", "zstd", "empty"))
+}
+
+func TestEmbed_unsupportedCompression(t *testing.T) {
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil, WithCompression("lzma", "att"))
+	assert.EqualError(t, err, `attachment "att": unsupported compression "lzma"`)
+}
+
+func TestEmbed_encrypted(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	content := strings.Repeat("secret content ", 10000)
+
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"encrypted":  strings.NewReader(content),
+		"compressed": strings.NewReader(content),
+		"plain":      strings.NewReader("public content"),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil,
+		WithEncryption("key-1", key, "encrypted", "compressed"),
+		WithCompression(CompressionGzip, "compressed"),
+	)
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "secret content")
+	path := writeTempFile(t, out.Bytes())
+
+	t.Run("with key", func(t *testing.T) {
+		var requested []string
+		provider := ember.KeyProviderFunc(func(keyID string) ([]byte, error) {
+			requested = append(requested, keyID)
+			return key, nil
+		})
+		att, err := ember.OpenExe(path, ember.WithKeyProvider(provider))
+		assert.NoError(t, err)
+		defer att.Close()
+
+		for _, name := range []string{"encrypted", "compressed"} {
+			assert.Equal(t, int64(len(content)), att.Size(name))
+			data, err := io.ReadAll(att.Reader(name))
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		}
+		assert.Less(t, att.StoredSize("compressed"), int64(len(content)))
+		assert.Equal(t, []string{"key-1"}, requested)
+
+		assert.NoError(t, att.VerifyAll())
+		assert.NoError(t, fstest.TestFS(att, "encrypted", "compressed", "plain"))
+	})
+
+	t.Run("without key provider", func(t *testing.T) {
+		att, err := ember.OpenExe(path)
+		assert.NoError(t, err)
+		defer att.Close()
+
+		data, err := io.ReadAll(att.Reader("plain"))
+		assert.NoError(t, err)
+		assert.Equal(t, "public content", string(data))
+
+		_, err = io.ReadAll(att.Reader("encrypted"))
+		assert.ErrorIs(t, err, ember.ErrNoKeyProvider)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrongKey := []byte("fedcba9876543210fedcba9876543210")
+		provider := ember.KeyProviderFunc(func(string) ([]byte, error) {
+			return wrongKey, nil
+		})
+		att, err := ember.OpenExe(path, ember.WithKeyProvider(provider))
+		assert.NoError(t, err)
+		defer att.Close()
+
+		_, err = io.ReadAll(att.Reader("encrypted"))
+		assert.ErrorIs(t, err, ember.ErrDecryption)
+		assert.ErrorIs(t, err, ember.ErrCorrupt)
+	})
+}
+
+func TestEmbed_invalidEncryptionKey(t *testing.T) {
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	err := Embed(&out, strings.NewReader(exe), attachments, nil, WithEncryption("id", []byte("short")))
+	assert.EqualError(t, err, "invalid encryption key size (expected 32 bytes)")
+}
+
+func TestEmbedFiles_metadata(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	configPath := filepath.Join(dir, "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte("{}"), 0600))
+	assert.NoError(t, os.Chmod(configPath, 0640))
+	assert.NoError(t, os.Chtimes(configPath, modTime, modTime))
+
+	dataPath := filepath.Join(dir, "data.bin")
+	assert.NoError(t, os.WriteFile(dataPath, []byte{1, 2, 3}, 0600))
+
+	var out bytes.Buffer
+	exe := prepareExecutableData()
+	err := EmbedFiles(&out, strings.NewReader(exe), map[string]string{
+		"config": configPath,
+		"data":   dataPath,
+	}, nil, WithFileModTimes(), WithMetadata("data", Metadata{
+		ContentType: "application/x-custom",
+		Labels:      map[string]string{"version": "3"},
+	}))
+	assert.NoError(t, err)
+	path := writeTempFile(t, out.Bytes())
+
+	att, err := ember.OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	info, err := att.Stat("config")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0640), info.Mode())
+	assert.True(t, modTime.Equal(info.ModTime()))
+	assert.Equal(t, "application/json", att.ContentType("config"))
+
+	info, err = att.Stat("data")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), info.Mode())
+	assert.Equal(t, "application/x-custom", att.ContentType("data"))
+	assert.Equal(t, map[string]string{"version": "3"}, att.Labels("data"))
+}
+
+func TestEmbedFiles_reproducible(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"c", "a", "d", "b"}
+	files := make(map[string]string)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte("content "+name), 0644))
+		files[name] = path
+	}
+	exe := prepareExecutableData()
+
+	embed := func(opts ...Option) []byte {
+		var out bytes.Buffer
+		err := EmbedFiles(&out, strings.NewReader(exe), files, nil, opts...)
+		assert.NoError(t, err)
+		return out.Bytes()
+	}
+
+	first := embed(WithCompression(CompressionGzip))
+	for _, name := range names { // modification times are not stored by default
+		modTime := time.Now().Add(time.Hour)
+		assert.NoError(t, os.Chtimes(files[name], modTime, modTime))
+	}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, first, embed(WithCompression(CompressionGzip)))
+	}
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	encrypted := embed(WithCompression(CompressionGzip), WithEncryption("key", key))
+	assert.Equal(t, encrypted, embed(WithCompression(CompressionGzip), WithEncryption("key", key)))
+	assert.NotEqual(t, encrypted, embed(WithCompression(CompressionGzip), WithEncryption("key", []byte("fedcba9876543210fedcba9876543210"))))
+
+	att, err := ember.OpenExe(writeTempFile(t, first))
+	assert.NoError(t, err)
+	defer att.Close()
+	assert.Equal(t, []string{"a", "b", "c", "d"}, att.List())
+	info, err := att.Stat("a")
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().IsZero())
+
+	t.Run("SOURCE_DATE_EPOCH", func(t *testing.T) {
+		epoch := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		t.Setenv(SourceDateEpochEnv, strconv.FormatInt(epoch.Unix(), 10))
+		older := epoch.Add(-time.Hour)
+		assert.NoError(t, os.Chtimes(files["a"], older, older))
+
+		data := embed(WithFileModTimes())
+		att, err := ember.OpenExe(writeTempFile(t, data))
+		assert.NoError(t, err)
+		defer att.Close()
+
+		info, err := att.Stat("a")
+		assert.NoError(t, err)
+		assert.True(t, older.Equal(info.ModTime()))
+		info, err = att.Stat("b")
+		assert.NoError(t, err)
+		assert.True(t, epoch.Equal(info.ModTime()))
+
+		t.Setenv(SourceDateEpochEnv, "yesterday")
+		var out bytes.Buffer
+		err = EmbedFiles(&out, strings.NewReader(exe), files, nil)
+		assert.ErrorContains(t, err, `invalid SOURCE_DATE_EPOCH "yesterday"`)
+	})
+}
+
 func Test_verifyTargetExe(t *testing.T) {
 	r := strings.NewReader(prepareExecutableData())
 	err := verifyTargetExe(r, false)
@@ -97,20 +400,47 @@ func Test_buildTOC(t *testing.T) {
 		"second": r2,
 	}
 
-	toc, err := buildTOC(attachments)
+	toc, err := buildTOC(attachments, applyOptions(nil))
 	assert.NoError(t, err)
 	assert.Len(t, toc, 2)
+	assert.Equal(t, "first", toc[0].Name)
+	assert.Equal(t, "second", toc[1].Name)
 
+	digest1 := sha256.Sum256([]byte("content 1"))
 	assert.Contains(t, toc, internal.Attachment{
-		Name: "first",
-		Size: 9,
+		Name:   "first",
+		Size:   9,
+		Digest: digest1[:],
 	})
+	digest2 := sha256.Sum256([]byte("second content"))
 	assert.Contains(t, toc, internal.Attachment{
-		Name: "second",
-		Size: 14,
+		Name:   "second",
+		Size:   14,
+		Digest: digest2[:],
 	})
 }
 
+func Test_buildTOC_salt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	content := strings.Repeat("secret content ", 100)
+
+	salts := make(map[Compression][]byte)
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		attachments := map[string]io.ReadSeeker{"att": strings.NewReader(content)}
+		toc, err := buildTOC(attachments, applyOptions([]Option{
+			WithEncryption("key", key),
+			WithCompression(compression),
+		}))
+		if assert.NoError(t, err) && assert.Len(t, toc, 1) {
+			salts[compression] = toc[0].Salt
+		}
+	}
+	// the same content stored with different compression must never share a salt (nonce reuse)
+	assert.NotEqual(t, salts[CompressionNone], salts[CompressionGzip])
+	assert.NotEqual(t, salts[CompressionNone], salts[CompressionZstd])
+	assert.NotEqual(t, salts[CompressionGzip], salts[CompressionZstd])
+}
+
 func prepareExecutableData() string {
 	randBytes := make([]byte, 0, 100)
 	if _, err := rand.Read(randBytes); err != nil {
@@ -126,3 +456,255 @@ func prepareExecutableData() string {
 	exeData = strings.ReplaceAll(exeData, "XXX", compatibleVersion)
 	return exeData
 }
+
+func writeTempFile(t *testing.T, data []byte) string {
+	file, err := os.CreateTemp("", "")
+	assert.NoError(t, err)
+	_, err = file.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	t.Cleanup(func() { _ = os.Remove(file.Name()) })
+	return file.Name()
+}
+
+func TestEmbed_signed(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att1": strings.NewReader("first content"),
+		"att2": strings.NewReader("second content"),
+	}
+
+	var signed bytes.Buffer
+	err = Embed(&signed, strings.NewReader(exe), attachments, nil, WithSigningKey(priv))
+	assert.NoError(t, err)
+	signedPath := writeTempFile(t, signed.Bytes())
+
+	var unsigned bytes.Buffer
+	err = Embed(&unsigned, strings.NewReader(exe), attachments, nil)
+	assert.NoError(t, err)
+	unsignedPath := writeTempFile(t, unsigned.Bytes())
+
+	t.Run("trusted key", func(t *testing.T) {
+		att, err := ember.OpenExe(signedPath, ember.WithTrustedKeys(otherPub, pub))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, att.Count())
+		assert.NoError(t, att.Close())
+	})
+
+	t.Run("no trusted keys", func(t *testing.T) {
+		att, err := ember.OpenExe(signedPath)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, att.Count())
+		assert.NoError(t, att.Close())
+	})
+
+	t.Run("untrusted key", func(t *testing.T) {
+		att, err := ember.OpenExe(signedPath, ember.WithTrustedKeys(otherPub))
+		assert.ErrorIs(t, err, ember.ErrInvalidSignature)
+		assert.Nil(t, att)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		att, err := ember.OpenExe(unsignedPath, ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrUnsigned)
+		assert.Nil(t, att)
+	})
+
+	t.Run("stripped", func(t *testing.T) {
+		att, err := ember.NewReader(strings.NewReader(exe), int64(len(exe)), ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrUnsigned)
+		assert.ErrorIs(t, err, ember.ErrCorrupt)
+		assert.Nil(t, att)
+	})
+
+	t.Run("tampered content", func(t *testing.T) {
+		data := bytes.Replace(signed.Bytes(), []byte("second content"), []byte("second CONTENT"), 1)
+		path := writeTempFile(t, data)
+
+		att, err := ember.OpenExe(path, ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrChecksumMismatch)
+		assert.Nil(t, att)
+	})
+
+	t.Run("signature only", func(t *testing.T) {
+		data := bytes.Replace(signed.Bytes(), []byte("second content"), []byte("second CONTENT"), 1)
+		att, err := ember.OpenExe(writeTempFile(t, data))
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer att.Close()
+
+		assert.NoError(t, att.VerifySignature(pub), "the content is not verified")
+		assert.ErrorIs(t, att.VerifySignature(otherPub), ember.ErrInvalidSignature)
+		assert.NoError(t, att.Verify("att1"))
+		assert.ErrorIs(t, att.Verify("att2"), ember.ErrChecksumMismatch)
+
+		unsigned, err := ember.OpenExe(unsignedPath)
+		if assert.NoError(t, err) {
+			assert.ErrorIs(t, unsigned.VerifySignature(pub), ember.ErrUnsigned)
+			assert.NoError(t, unsigned.Close())
+		}
+	})
+
+	t.Run("tampered TOC", func(t *testing.T) {
+		data := bytes.Replace(signed.Bytes(), []byte("att1"), []byte("att0"), 1)
+		path := writeTempFile(t, data)
+
+		att, err := ember.OpenExe(path, ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrInvalidSignature)
+		assert.ErrorIs(t, err, ember.ErrCorrupt)
+		assert.Nil(t, att)
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		overlay := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(overlay, "att1"), []byte("tampered"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(overlay, "att3"), []byte("injected"), 0644))
+		t.Setenv(ember.OverlayEnv, overlay)
+
+		// the environment variable is ignored if trusted keys are configured
+		att, err := ember.OpenExe(signedPath, ember.WithTrustedKeys(pub))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"att1", "att2"}, att.List())
+			data, err := io.ReadAll(att.Reader("att1"))
+			assert.NoError(t, err)
+			assert.Equal(t, "first content", string(data))
+			assert.NoError(t, att.VerifyAll())
+			assert.NoError(t, att.Close())
+		}
+
+		_, err = ember.OpenExe(signedPath, ember.WithTrustedKeys(pub), ember.WithOverlay(overlay))
+		assert.Error(t, err)
+
+		// without trusted keys, overlay files are used but can not be verified
+		att, err = ember.OpenExe(signedPath)
+		if assert.NoError(t, err) {
+			assert.True(t, att.FromOverlay("att1"))
+			assert.ErrorIs(t, att.Verify("att1"), ember.ErrNoDigest)
+			assert.ErrorIs(t, att.VerifyAll(), ember.ErrNoDigest)
+			assert.NoError(t, att.Verify("att2"))
+			assert.NoError(t, att.Close())
+		}
+	})
+}
+
+func TestRemoveEmbedding_signed(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	var signed bytes.Buffer
+	err = Embed(&signed, strings.NewReader(exe), attachments, nil, WithSigningKey(priv))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	err = RemoveEmbedding(&out, bytes.NewReader(signed.Bytes()), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, exe, out.String())
+}
+
+func TestEmbedLayer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	exe := prepareExecutableData()
+	var base bytes.Buffer
+	err = Embed(&base, strings.NewReader(exe), map[string]io.ReadSeeker{
+		"config":  strings.NewReader("old config"),
+		"removed": strings.NewReader("removed"),
+		"kept":    strings.NewReader("kept"),
+	}, nil, WithSigningKey(priv))
+	assert.NoError(t, err)
+
+	var layered bytes.Buffer
+	err = EmbedLayer(&layered, bytes.NewReader(base.Bytes()), map[string]io.ReadSeeker{
+		"config": strings.NewReader("new config"),
+		"added":  strings.NewReader("added"),
+	}, nil, WithTombstones("removed"), WithCompression(CompressionGzip), WithSigningKey(priv))
+	assert.NoError(t, err)
+	assert.Equal(t, base.Bytes(), layered.Bytes()[:base.Len()])
+
+	path := writeTempFile(t, layered.Bytes())
+	att, err := ember.OpenExe(path, ember.WithTrustedKeys(pub))
+	assert.NoError(t, err)
+	defer att.Close()
+
+	for name, expected := range map[string]string{
+		"config": "new config",
+		"added":  "added",
+		"kept":   "kept",
+	} {
+		content, err := io.ReadAll(att.Reader(name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+	assert.Equal(t, 3, att.Count())
+	assert.Nil(t, att.Reader("removed"))
+
+	t.Run("unsigned layer", func(t *testing.T) {
+		var out bytes.Buffer
+		err = EmbedLayer(&out, bytes.NewReader(layered.Bytes()), map[string]io.ReadSeeker{
+			"config": strings.NewReader("unsigned config"),
+		}, nil)
+		assert.NoError(t, err)
+
+		_, err := ember.OpenExe(writeTempFile(t, out.Bytes()), ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrUnsigned)
+	})
+
+	t.Run("moved layer", func(t *testing.T) {
+		// same layout, but different content
+		var other bytes.Buffer
+		err = Embed(&other, strings.NewReader(exe), map[string]io.ReadSeeker{
+			"config":  strings.NewReader("OLD CONFIG"),
+			"removed": strings.NewReader("removed"),
+			"kept":    strings.NewReader("kept"),
+		}, nil, WithSigningKey(priv))
+		assert.NoError(t, err)
+		assert.Equal(t, base.Len(), other.Len())
+
+		data := append(other.Bytes(), layered.Bytes()[base.Len():]...)
+		_, err := ember.OpenExe(writeTempFile(t, data), ember.WithTrustedKeys(pub))
+		assert.ErrorIs(t, err, ember.ErrInvalidSignature)
+	})
+
+	t.Run("remove embedding", func(t *testing.T) {
+		var out bytes.Buffer
+		err = RemoveEmbedding(&out, bytes.NewReader(layered.Bytes()), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, exe, out.String())
+	})
+}
+
+func TestEmbedLayer_invalidTarget(t *testing.T) {
+	exe := prepareExecutableData()
+	attachments := map[string]io.ReadSeeker{
+		"att": strings.NewReader("content"),
+	}
+
+	err := EmbedLayer(io.Discard, strings.NewReader(exe), attachments, nil)
+	assert.ErrorIs(t, err, ErrNothingEmbedded)
+
+	var base bytes.Buffer
+	assert.NoError(t, Embed(&base, strings.NewReader(exe), attachments, nil))
+
+	// bundles without footer (eg. followed by other data) can not be extended
+	withoutFooter := base.Bytes()[:base.Len()-internal.FooterSize]
+	err = EmbedLayer(io.Discard, bytes.NewReader(withoutFooter), attachments, nil)
+	assert.Error(t, err)
+
+	err = EmbedLayer(io.Discard, bytes.NewReader(base.Bytes()), attachments, nil, WithTombstones("att"))
+	assert.Error(t, err)
+
+	err = Embed(io.Discard, strings.NewReader(exe), attachments, nil, WithTombstones("other"))
+	assert.Error(t, err)
+}
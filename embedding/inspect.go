@@ -0,0 +1,254 @@
+package embedding
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/maja42/ember/internal"
+)
+
+// ErrChecksumMismatch is returned if extracted content does not match the stored digest.
+// It is the same error as ember.ErrChecksumMismatch.
+var ErrChecksumMismatch = internal.ErrChecksumMismatch
+
+// Bundle describes the attachments of an augmented executable, as returned by Inspect.
+type Bundle struct {
+	ExeSize int64       // Size of the original executable without attachments
+	Layers  []LayerInfo // Layers in the order of their location within the executable
+
+	exe     io.ReaderAt
+	entries []existingAttachment // merged attachments of all layers
+	index   map[string]int       // attachment name -> position in entries
+}
+
+// LayerInfo describes a single layer of attachments.
+type LayerInfo struct {
+	Offset    int64             // Start of the layer within the executable
+	End       int64             // End of the layer (including signature and footer)
+	TOCOffset int64             // Start of the TOC
+	TOCSize   int64             // Size of the TOC in bytes
+	TOCFormat int               // TOC format version (1: JSON, 2: binary)
+	Entries   int               // Number of TOC entries, including tombstones
+	PublicKey ed25519.PublicKey // Key used for signing the layer; nil if unsigned. The signature is not verified
+}
+
+// AttachmentInfo describes a single attachment.
+type AttachmentInfo struct {
+	Name        string
+	Offset      int64  // Start of the stored data within the executable
+	Size        int64  // Size of the content in bytes
+	StoredSize  int64  // Number of bytes the (compressed and encrypted) data occupies within the executable
+	Digest      []byte // SHA-256 digest (HMAC-SHA256 if encrypted) of the content; nil if unknown
+	Compression Compression
+	Encryption  string // Encryption scheme; empty if unencrypted
+	KeyID       string // Identifies the key used for encryption
+	Metadata    Metadata
+	Layer       int // Index of the layer containing the attachment
+}
+
+// existingAttachment is an attachment of an augmented executable.
+type existingAttachment struct {
+	internal.Attachment
+	offset int64 // absolute offset of the stored data
+	layer  int
+}
+
+// Inspect parses the attachments of an augmented executable, without running it.
+// This is safe for untrusted executables and executables built for other platforms.
+//
+// The returned bundle reads from exe, which must stay usable as long as the bundle is in use.
+// All accesses are serialized, and the reader's position is changed arbitrarily.
+// Returns ErrNothingEmbedded if the executable does not contain attachments.
+func Inspect(exe io.ReadSeeker) (*Bundle, error) {
+	size, err := exe.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return inspect(&internal.ReadSeekerAt{RS: exe}, size)
+}
+
+func inspect(exe io.ReaderAt, size int64) (*Bundle, error) {
+	layers, err := internal.ParseLayers(exe, size)
+	if err != nil {
+		return nil, fmt.Errorf("read attachments: %w", err)
+	}
+	if len(layers) == 0 {
+		return nil, ErrNothingEmbedded
+	}
+
+	b := &Bundle{
+		ExeSize: layers[0].Start(),
+		exe:     exe,
+		index:   make(map[string]int),
+	}
+	var removed []bool
+	for i, l := range layers {
+		info := LayerInfo{
+			Offset:    l.Start(),
+			End:       l.End,
+			TOCOffset: l.TOCOffset,
+			TOCSize:   int64(len(l.TOC)),
+			TOCFormat: 1,
+			Entries:   l.Index.Len(),
+		}
+		if internal.IsBinaryTOC(l.TOC) {
+			info.TOCFormat = internal.TOCVersion
+		}
+		if l.Signature != nil {
+			info.PublicKey = l.Signature.PublicKey
+		}
+		b.Layers = append(b.Layers, info)
+
+		// later layers override or remove attachments; replaced attachments keep their position
+		for j := 0; j < l.Index.Len(); j++ {
+			a, offset := l.Entry(j)
+			pos, ok := b.index[a.Name]
+			switch {
+			case !ok && !a.Tombstone:
+				b.index[a.Name] = len(b.entries)
+				b.entries = append(b.entries, existingAttachment{a, offset, i})
+				removed = append(removed, false)
+			case ok:
+				b.entries[pos] = existingAttachment{a, offset, i}
+				removed[pos] = a.Tombstone
+			}
+		}
+	}
+
+	entries := b.entries[:0]
+	for pos, e := range b.entries {
+		if !removed[pos] {
+			entries = append(entries, e)
+		}
+	}
+	b.entries = entries
+	b.index = make(map[string]int, len(entries))
+	for pos, e := range entries {
+		b.index[e.Name] = pos
+	}
+	return b, nil
+}
+
+// signed returns true if any layer is signed.
+func (b *Bundle) signed() bool {
+	for _, l := range b.Layers {
+		if l.PublicKey != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Attachments returns all attachments, in the order in which the application lists them.
+func (b *Bundle) Attachments() []AttachmentInfo {
+	list := make([]AttachmentInfo, len(b.entries))
+	for i, e := range b.entries {
+		list[i] = e.info()
+	}
+	return list
+}
+
+// Attachment returns the attachment with the given name.
+func (b *Bundle) Attachment(name string) (AttachmentInfo, bool) {
+	e, ok := b.entry(name)
+	if !ok {
+		return AttachmentInfo{}, false
+	}
+	return e.info(), true
+}
+
+func (b *Bundle) entry(name string) (existingAttachment, bool) {
+	pos, ok := b.index[name]
+	if !ok {
+		return existingAttachment{}, false
+	}
+	return b.entries[pos], true
+}
+
+func (e *existingAttachment) info() AttachmentInfo {
+	info := AttachmentInfo{
+		Name:        e.Name,
+		Offset:      e.offset,
+		Size:        e.Size,
+		StoredSize:  e.Stored(),
+		Digest:      e.Digest,
+		Compression: Compression(e.Compression),
+		Encryption:  e.Encryption,
+		KeyID:       e.KeyID,
+		Metadata: Metadata{
+			Mode:        fs.FileMode(e.Mode),
+			ContentType: e.ContentType,
+			Labels:      e.Labels,
+		},
+		Layer: e.layer,
+	}
+	if e.ModTime != 0 {
+		info.Metadata.ModTime = time.Unix(0, e.ModTime)
+	}
+	return info
+}
+
+// RawReader returns the stored (compressed and encrypted) data of an attachment.
+func (b *Bundle) RawReader(name string) (*io.SectionReader, error) {
+	e, ok := b.entry(name)
+	if !ok {
+		return nil, fmt.Errorf("attachment %q: not found", name)
+	}
+	return io.NewSectionReader(b.exe, e.offset, e.Stored()), nil
+}
+
+// Extract writes the content of an attachment to w.
+// Compressed attachments are decompressed, encrypted attachments are decrypted with the given key
+// (which is ignored for unencrypted attachments).
+//
+// If a digest is stored, the content is verified. In case of a mismatch, ErrChecksumMismatch is returned
+// after the content was written.
+func (b *Bundle) Extract(w io.Writer, name string, key []byte) error {
+	e, ok := b.entry(name)
+	if !ok {
+		return fmt.Errorf("attachment %q: not found", name)
+	}
+	if err := e.extract(w, b.exe, key); err != nil {
+		return fmt.Errorf("attachment %q: %w", name, err)
+	}
+	return nil
+}
+
+func (e *existingAttachment) extract(w io.Writer, exe io.ReaderAt, key []byte) error {
+	var data io.Reader = io.NewSectionReader(exe, e.offset, e.Stored())
+	if e.Encryption != internal.EncryptionNone {
+		if key == nil {
+			return fmt.Errorf("encrypted with key %q, but no key given", e.KeyID)
+		}
+		dec, err := internal.NewDecryptor(io.NewSectionReader(exe, e.offset, e.Stored()), e.Stored(), key, e.Salt)
+		if err != nil {
+			return err
+		}
+		data = io.NewSectionReader(dec, 0, dec.Size())
+	}
+	if e.Compression != internal.CompressionNone {
+		dec, err := internal.NewDecompressor(e.Compression, data)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		data = dec
+	}
+
+	h := internal.NewDigest(e.Encryption, key, e.Salt)
+	n, err := io.Copy(io.MultiWriter(w, h), data)
+	if err != nil {
+		return err
+	}
+	if n != e.Size {
+		return ErrChecksumMismatch
+	}
+	if len(e.Digest) > 0 && !bytes.Equal(h.Sum(nil), e.Digest) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package embedding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	src := prepareAugmented(t, map[string]string{
+		"a": strings.Repeat("a", 100),
+		"b": "first layer",
+		"c": "removed",
+	}, map[string]string{
+		"b": "second layer",
+	}, WithCompression(CompressionGzip), WithEncryption("key", key),
+		WithMetadata("a", Metadata{ContentType: "text/plain", Labels: map[string]string{"k": "v"}}))
+	var out bytes.Buffer
+	err := EmbedLayer(&out, bytes.NewReader(src), nil, nil, WithTombstones("c"))
+	assert.NoError(t, err)
+	src = out.Bytes()
+
+	b, err := Inspect(bytes.NewReader(src))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(len(prepareExecutableData())), b.ExeSize)
+	if assert.Len(t, b.Layers, 3) {
+		assert.Equal(t, b.ExeSize, b.Layers[0].Offset)
+		assert.Equal(t, b.Layers[0].End, b.Layers[1].Offset)
+		assert.Equal(t, b.Layers[1].End, b.Layers[2].Offset)
+		assert.Equal(t, int64(len(src)), b.Layers[2].End)
+		assert.Equal(t, 2, b.Layers[0].TOCFormat)
+		assert.Equal(t, 3, b.Layers[0].Entries)
+		assert.Nil(t, b.Layers[0].PublicKey)
+	}
+
+	list := b.Attachments()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "a", list[0].Name)
+		assert.Equal(t, "b", list[1].Name)
+		assert.Equal(t, 1, list[1].Layer)
+	}
+
+	info, ok := b.Attachment("a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), info.Size)
+	assert.Equal(t, CompressionGzip, info.Compression)
+	assert.Equal(t, "key", info.KeyID)
+	assert.NotEmpty(t, info.Digest)
+	assert.Equal(t, "text/plain", info.Metadata.ContentType)
+	assert.Equal(t, map[string]string{"k": "v"}, info.Metadata.Labels)
+
+	raw, err := b.RawReader("a")
+	assert.NoError(t, err)
+	assert.Equal(t, info.StoredSize, raw.Size())
+	assert.True(t, info.Offset+info.StoredSize <= b.Layers[0].End)
+
+	var content bytes.Buffer
+	assert.NoError(t, b.Extract(&content, "b", key))
+	assert.Equal(t, "second layer", content.String())
+
+	_, ok = b.Attachment("c")
+	assert.False(t, ok, "removed by tombstone")
+	assert.EqualError(t, b.Extract(&content, "c", key), `attachment "c": not found`)
+	assert.EqualError(t, b.Extract(&content, "a", nil), `attachment "a": encrypted with key "key", but no key given`)
+}
+
+func TestInspect_checksumMismatch(t *testing.T) {
+	src := prepareAugmented(t, map[string]string{"a": "content"}, nil)
+	b, err := Inspect(bytes.NewReader(src))
+	if !assert.NoError(t, err) {
+		return
+	}
+	info, _ := b.Attachment("a")
+	src[info.Offset] = 'X'
+
+	var content bytes.Buffer
+	err = b.Extract(&content, "a", nil)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.ErrorIs(t, err, ember.ErrChecksumMismatch)
+	assert.ErrorIs(t, err, ember.ErrCorrupt)
+	assert.Equal(t, "Xontent", content.String())
+}
+
+func TestInspect_nothingEmbedded(t *testing.T) {
+	_, err := Inspect(strings.NewReader(prepareExecutableData()))
+	assert.ErrorIs(t, err, ErrNothingEmbedded)
+}
@@ -0,0 +1,58 @@
+package embedding
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	"github.com/maja42/ember/internal"
+)
+
+// GenerateSigningKey creates a new Ed25519 key pair for signing attachments.
+// Returns the PEM-encoded private key (PKCS #8) and public key (PKIX).
+//
+// The public key can be parsed by the application via ember.ParsePublicKey.
+func GenerateSigningKey() (privateKey, publicKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return privateKey, publicKey, nil
+}
+
+// ParseSigningKey parses a PEM-encoded Ed25519 private key, as created by GenerateSigningKey.
+func ParseSigningKey(pemData []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+	return edKey, nil
+}
+
+// ParseEncryptionKey parses a 32-byte key for encrypting attachments.
+// The key can be hex- or base64-encoded, or consist of raw bytes (which must not be printable text).
+func ParseEncryptionKey(data []byte) ([]byte, error) {
+	return internal.ParseKey(data)
+}
@@ -0,0 +1,30 @@
+package embedding
+
+import (
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSigningKey(t *testing.T) {
+	privPEM, pubPEM, err := GenerateSigningKey()
+	assert.NoError(t, err)
+
+	priv, err := ParseSigningKey(privPEM)
+	assert.NoError(t, err)
+
+	pub, err := ember.ParsePublicKey(pubPEM)
+	assert.NoError(t, err)
+	assert.True(t, pub.Equal(priv.Public()))
+}
+
+func TestParseSigningKey_invalid(t *testing.T) {
+	_, err := ParseSigningKey([]byte("no pem"))
+	assert.EqualError(t, err, "no PEM data found")
+
+	_, pubPEM, err := GenerateSigningKey()
+	assert.NoError(t, err)
+	_, err = ParseSigningKey(pubPEM)
+	assert.Error(t, err)
+}
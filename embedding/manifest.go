@@ -0,0 +1,377 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestVersion is the latest version of the manifest format.
+const ManifestVersion = 1
+
+// Manifest describes the attachments to embed, together with per-attachment options.
+// See LoadManifest for the file format.
+type Manifest struct {
+	Version     int             `json:"version"`
+	Attachments []ManifestEntry `json:"attachments"`
+}
+
+// ManifestEntry describes a single attachment.
+// Either Path or Content must be set.
+type ManifestEntry struct {
+	Name        string            `json:"name"`
+	Path        string            `json:"path,omitempty"`        // File containing the content. Can be StdinPath
+	Content     *string           `json:"content,omitempty"`     // Inline content
+	Compression Compression       `json:"compression,omitempty"` // Overrides the default compression
+	ContentType string            `json:"contentType,omitempty"` // Defaults to the type of the file extension
+	Mode        ManifestMode      `json:"mode,omitempty"`        // Defaults to the mode of the file
+	Labels      map[string]string `json:"labels,omitempty"`
+	Optional    bool              `json:"optional,omitempty"` // Skip the attachment if the file does not exist
+}
+
+// ManifestMode is an octal file mode, like "0644".
+// Manifests can specify it as string or as number; numbers are interpreted as octal as well (644 is "0644").
+type ManifestMode string
+
+// UnmarshalJSON accepts strings and numbers.
+func (m *ManifestMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if json.Unmarshal(data, &n) != nil {
+			return err
+		}
+		s = n.String()
+	}
+	*m = ManifestMode(s)
+	return nil
+}
+
+// LoadManifest reads a manifest from a JSON or YAML file (based on the file extension: ".yaml" or ".yml").
+//
+// Manifests contain a version and a list of attachments:
+//
+//	{
+//	  "version": 1,
+//	  "attachments": [
+//	    {"name": "config.json", "path": "config/${STAGE}.json", "compression": "zstd", "labels": {"stage": "${STAGE}"}},
+//	    {"name": "motd.txt", "content": "Welcome!", "mode": "0600", "optional": false}
+//	  ]
+//	}
+//
+// Relative paths are resolved against the directory containing the manifest.
+// Environment variables (${VAR} or $VAR) are expanded in names, paths, content types and label values,
+// but not in inline content. Use $$ for a literal '$'. Undefined variables are an error.
+//
+// YAML manifests have the same structure. File modes can be written without quotes (mode: 0600).
+//
+// For compatibility, a flat object mapping attachment names to paths is accepted as well.
+// Such paths are used as-is, without resolving them against the manifest's directory or expanding variables.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		data, err = yamlToJSON(data)
+	}
+	var m *Manifest
+	if err == nil {
+		m, err = ParseManifest(data, filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest %q: %w", path, err)
+	}
+	return m, nil
+}
+
+// ParseManifest decodes a JSON manifest. Relative paths are resolved against dir.
+// Use LoadManifest for YAML manifests.
+//
+// See LoadManifest for more information.
+func ParseManifest(data []byte, dir string) (*Manifest, error) {
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version == 0 { // flat map
+		return m, nil
+	}
+	if err := m.resolve(dir, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseManifest decodes a manifest. The version of flat maps is zero.
+func parseManifest(data []byte) (*Manifest, error) {
+	decode := func(v interface{}, strict bool) error {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	}
+
+	var probe map[string]interface{}
+	if err := decode(&probe, false); err != nil {
+		return nil, err
+	}
+	version, versioned := probe["version"]
+	_, hasList := probe["attachments"].([]interface{}) // not a flat map
+	switch v := version.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return nil, fmt.Errorf("invalid version %s (must be an integer)", v)
+		}
+	case string: // flat map; possibly with an attachment called "version"
+		if _, err := strconv.Atoi(strings.TrimSpace(v)); err == nil || hasList {
+			return nil, fmt.Errorf("invalid version %q (must be an integer, not a string)", v)
+		}
+		versioned = false
+	case nil:
+		versioned = false
+	default:
+		return nil, fmt.Errorf("invalid version %v (must be an integer)", v)
+	}
+	if !versioned {
+		var files map[string]string
+		if err := decode(&files, true); err != nil {
+			return nil, fmt.Errorf("expected a versioned manifest, or a flat map of names to paths: %w", err)
+		}
+		return flatManifest(files), nil
+	}
+
+	var m Manifest
+	if err := decode(&m, true); err != nil {
+		return nil, err
+	}
+	if m.Version < 1 || m.Version > ManifestVersion {
+		return nil, fmt.Errorf("unsupported version %d (latest supported version is %d)", m.Version, ManifestVersion)
+	}
+	return &m, nil
+}
+
+// flatManifest converts a map of attachment names to paths into a manifest.
+// Entries are sorted by name.
+func flatManifest(files map[string]string) *Manifest {
+	m := &Manifest{}
+	for _, name := range sortedKeys(files) {
+		m.Attachments = append(m.Attachments, ManifestEntry{Name: name, Path: files[name]})
+	}
+	return m
+}
+
+// resolve expands environment variables and makes relative paths relative to dir.
+func (m *Manifest) resolve(dir string, lookupEnv func(string) (string, bool)) error {
+	var undefined []string
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			value, ok := lookupEnv(name)
+			if !ok {
+				undefined = append(undefined, name)
+			}
+			return value
+		})
+	}
+
+	for i := range m.Attachments {
+		e := &m.Attachments[i]
+		e.Name = expand(e.Name)
+		e.Path = expand(e.Path)
+		e.ContentType = expand(e.ContentType)
+		for k, v := range e.Labels {
+			e.Labels[k] = expand(v)
+		}
+		if len(undefined) > 0 {
+			return fmt.Errorf("attachment %q: undefined environment variable %q", e.Name, undefined[0])
+		}
+		if e.Path != "" && e.Path != StdinPath && !filepath.IsAbs(e.Path) {
+			e.Path = filepath.Join(dir, filepath.FromSlash(e.Path))
+		}
+	}
+	return m.validate()
+}
+
+// validate ensures that all entries are well-formed.
+func (m *Manifest) validate() error {
+	names := make(map[string]bool, len(m.Attachments))
+	for i, e := range m.Attachments {
+		if e.Name == "" {
+			return fmt.Errorf("attachment #%d: empty name", i+1)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("attachment %q: listed multiple times", e.Name)
+		}
+		names[e.Name] = true
+
+		if (e.Path == "") == (e.Content == nil) {
+			return fmt.Errorf("attachment %q: either path or content is required", e.Name)
+		}
+		if e.Content != nil && e.Optional {
+			return fmt.Errorf("attachment %q: inline content can not be optional", e.Name)
+		}
+		if _, err := e.mode(); err != nil {
+			return fmt.Errorf("attachment %q: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// mode parses the file mode of the entry. Returns zero if it is not set.
+func (e *ManifestEntry) mode() (fs.FileMode, error) {
+	if e.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(string(e.Mode), 8, 32)
+	if err != nil || fs.FileMode(mode)&^fs.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid mode %q (expected octal permission bits, like \"0644\")", e.Mode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// EmbedManifest embeds the attachments described by the manifest into the target executable.
+//
+// Optional attachments whose file does not exist are skipped.
+// Options of the manifest entries take precedence over opts.
+//
+// See EmbedFiles for more information.
+func EmbedManifest(out io.Writer, exe io.ReadSeeker, m *Manifest, logger PrintlnFunc, opts ...Option) error {
+	return withManifest(m, logger, opts, func(reader map[string]io.ReadSeeker, opts []Option) error {
+		return Embed(out, exe, reader, logger, opts...)
+	})
+}
+
+// EmbedLayerManifest appends a new layer with the attachments described by the manifest
+// to an executable that already contains attachments.
+//
+// See EmbedManifest and EmbedLayer for more information.
+func EmbedLayerManifest(out io.Writer, exe io.ReadSeeker, m *Manifest, logger PrintlnFunc, opts ...Option) error {
+	return withManifest(m, logger, opts, func(reader map[string]io.ReadSeeker, opts []Option) error {
+		return EmbedLayer(out, exe, reader, logger, opts...)
+	})
+}
+
+// withManifest opens the attachments of the manifest and passes them to fn, together with options
+// for storing them as described.
+func withManifest(m *Manifest, logger PrintlnFunc, opts []Option, fn func(map[string]io.ReadSeeker, []Option) error) error {
+	if logger == nil {
+		logger = func(string, ...interface{}) {}
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	files := make(map[string]string)
+	inline := make(map[string]io.ReadSeeker)
+	var entryOpts []Option
+	for _, e := range m.Attachments {
+		if e.Content != nil {
+			inline[e.Name] = strings.NewReader(*e.Content)
+			entryOpts = append(entryOpts, WithMetadata(e.Name, Metadata{
+				ContentType: contentTypeOf(e.Name),
+			}))
+		} else {
+			if e.Optional && e.Path != StdinPath {
+				if _, err := os.Stat(e.Path); errors.Is(err, fs.ErrNotExist) {
+					logger("Skipping optional attachment %q (%q does not exist)", e.Name, e.Path)
+					continue
+				}
+			}
+			files[e.Name] = e.Path
+		}
+
+		if e.Compression != "" {
+			entryOpts = append(entryOpts, WithCompression(e.Compression, e.Name))
+		}
+		mode, _ := e.mode()
+		entryOpts = append(entryOpts, WithMetadata(e.Name, Metadata{
+			Mode:        mode,
+			ContentType: e.ContentType,
+			Labels:      e.Labels,
+		}))
+	}
+
+	return withFiles(files, opts, func(reader map[string]io.ReadSeeker, fileOpts []Option) error {
+		for name, r := range inline {
+			reader[name] = r
+		}
+		return fn(reader, append(fileOpts, entryOpts...))
+	})
+}
+
+// yamlToJSON converts a YAML document into JSON, so that it can be decoded like JSON manifests.
+// Numbers that are not valid in JSON (like the file mode 0644) are converted into strings.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 { // empty document
+		return []byte("null"), nil
+	}
+	v, err := yamlValue(doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// yamlValue converts a YAML node into a value that can be encoded as JSON.
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[n.Content[i].Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(n.Content))
+		for i, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			err := n.Decode(&b)
+			return b, err
+		case "!!int", "!!float":
+			if json.Valid([]byte(n.Value)) {
+				return json.Number(n.Value), nil
+			}
+		}
+		return n.Value, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported YAML node", n.Line)
+}
@@ -0,0 +1,237 @@
+package embedding
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"assets/config.prod.json": `{"stage":"prod"}`,
+	})
+	t.Setenv("EMBER_TEST_STAGE", "prod")
+
+	path := filepath.Join(dir, "manifest.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+	"version": 1,
+	"attachments": [
+		{"name": "config.json", "path": "assets/config.${EMBER_TEST_STAGE}.json", "compression": "zstd",
+		 "labels": {"stage": "${EMBER_TEST_STAGE}", "price": "$$5"}},
+		{"name": "motd.txt", "content": "Hello ${USER}", "mode": "0600", "contentType": "text/plain"}
+	]
+}`), 0644))
+
+	m, err := LoadManifest(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	content := "Hello ${USER}"
+	assert.Equal(t, &Manifest{
+		Version: 1,
+		Attachments: []ManifestEntry{{
+			Name:        "config.json",
+			Path:        filepath.Join(dir, "assets", "config.prod.json"),
+			Compression: CompressionZstd,
+			Labels:      map[string]string{"stage": "prod", "price": "$5"},
+		}, {
+			Name:        "motd.txt",
+			Content:     &content,
+			Mode:        "0600",
+			ContentType: "text/plain",
+		}},
+	}, m)
+}
+
+func TestLoadManifest_yaml(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EMBER_TEST_STAGE", "prod")
+	path := filepath.Join(dir, "manifest.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+version: 1
+attachments:
+  - name: config.json
+    path: assets/config.${EMBER_TEST_STAGE}.json
+    compression: zstd
+    labels: {stage: "${EMBER_TEST_STAGE}", price: "$$5"}
+    optional: true
+  - name: motd.txt
+    content: "Hello ${USER}"
+    mode: 0600
+    contentType: text/plain
+  - name: script.sh
+    path: /bin/script.sh
+    mode: 755
+`), 0644))
+
+	m, err := LoadManifest(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	content := "Hello ${USER}"
+	assert.Equal(t, &Manifest{
+		Version: 1,
+		Attachments: []ManifestEntry{{
+			Name:        "config.json",
+			Path:        filepath.Join(dir, "assets", "config.prod.json"),
+			Compression: CompressionZstd,
+			Labels:      map[string]string{"stage": "prod", "price": "$5"},
+			Optional:    true,
+		}, {
+			Name:        "motd.txt",
+			Content:     &content,
+			Mode:        "0600",
+			ContentType: "text/plain",
+		}, {
+			Name: "script.sh",
+			Path: "/bin/script.sh",
+			Mode: "755",
+		}},
+	}, m)
+	mode, err := m.Attachments[2].mode()
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0755), mode, "numeric modes are octal")
+}
+
+func TestLoadManifest_flat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"b": "path/b", "a": "path/a", "version": "path/v"}`), 0644))
+
+	m, err := LoadManifest(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &Manifest{Attachments: []ManifestEntry{
+		{Name: "a", Path: "path/a"},
+		{Name: "b", Path: "path/b"},
+		{Name: "version", Path: "path/v"},
+	}}, m, "paths are not resolved")
+}
+
+func TestLoadManifest_flatYAML(t *testing.T) {
+	for _, file := range []string{"list.yml", "list.YAML"} {
+		path := filepath.Join(t.TempDir(), file)
+		assert.NoError(t, os.WriteFile(path, []byte("b: path/b\na: path/a\nversion: path/v\n"), 0644))
+
+		m, err := LoadManifest(path)
+		assert.NoError(t, err)
+		assert.Equal(t, &Manifest{Attachments: []ManifestEntry{
+			{Name: "a", Path: "path/a"},
+			{Name: "b", Path: "path/b"},
+			{Name: "version", Path: "path/v"},
+		}}, m, "paths are not resolved")
+	}
+}
+
+func TestLoadManifest_invalid(t *testing.T) {
+	tests := map[string]string{
+		`{"version": 2, "attachments": []}`:                                                       "unsupported version 2",
+		`{"version": 1, "attachments": [{"name": "a", "path": "a", "unknown": true}]}`:            `unknown field "unknown"`,
+		`{"version": 1, "attachments": [{"name": "a"}]}`:                                          `attachment "a": either path or content is required`,
+		`{"version": 1, "attachments": [{"name": "a", "path": "a", "content": ""}]}`:              `attachment "a": either path or content is required`,
+		`{"version": 1, "attachments": [{"path": "a"}]}`:                                          "attachment #1: empty name",
+		`{"version": 1, "attachments": [{"name": "a", "path": "a"}, {"name": "a", "path": "b"}]}`: `attachment "a": listed multiple times`,
+		`{"version": 1, "attachments": [{"name": "a", "path": "a", "mode": "rw"}]}`:               `attachment "a": invalid mode "rw"`,
+		`{"version": 1, "attachments": [{"name": "a", "path": "a", "mode": 1000}]}`:               `attachment "a": invalid mode "1000"`,
+		`{"version": 1, "attachments": [{"name": "a", "path": "a", "mode": true}]}`:               "cannot unmarshal bool",
+		`{"version": 1, "attachments": [{"name": "a", "path": "${EMBER_TEST_UNDEFINED}"}]}`:       `undefined environment variable "EMBER_TEST_UNDEFINED"`,
+		`{"a": 1}`:                                      "expected a versioned manifest, or a flat map of names to paths",
+		`{"version": "1", "attachments": []}`:           `invalid version "1" (must be an integer, not a string)`,
+		`{"version": "1"}`:                              `invalid version "1" (must be an integer, not a string)`,
+		`{"version": "v1", "attachments": []}`:          `invalid version "v1" (must be an integer, not a string)`,
+		`{"version": 1.5, "attachments": []}`:           "invalid version 1.5 (must be an integer)",
+		`{"version": true, "attachments": []}`:          "invalid version true (must be an integer)",
+		`{"version": null, "attachments": []}`:          "expected a versioned manifest, or a flat map of names to paths",
+		`{"attachments": [{"name": "a", "path": "a"}]}`: "expected a versioned manifest, or a flat map of names to paths",
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	for content, expected := range tests {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := LoadManifest(path)
+		assert.ErrorContains(t, err, expected, content)
+	}
+}
+
+func TestLoadManifest_invalidYAML(t *testing.T) {
+	tests := map[string]string{
+		"version: \"1\"\nattachments: []\n":                              `invalid version "1" (must be an integer, not a string)`,
+		"version: 1.5\nattachments: []\n":                                "invalid version 1.5 (must be an integer)",
+		"version: 1\nattachments:\n  - {name: a, path: a, unknown: 1}\n": `unknown field "unknown"`,
+		"version: 1\nattachments:\n  - {name: a, path: a, mode: 999}\n":  `attachment "a": invalid mode "999"`,
+		"version: 1\nattachments: [\n":                                   "did not find expected node content",
+		"- a\n- b\n":                                                     "cannot unmarshal array",
+	}
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	for content, expected := range tests {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := LoadManifest(path)
+		assert.ErrorContains(t, err, expected, content)
+	}
+}
+
+func Test_yamlToJSON(t *testing.T) {
+	tests := map[string]string{
+		"":                        "null",
+		"a: 1":                    `{"a":1}`,
+		"a: 0644":                 `{"a":"0644"}`,
+		"a: 0x10":                 `{"a":"0x10"}`,
+		"a: [true, ~, 1.5, text]": `{"a":[true,null,1.5,"text"]}`,
+		"a: &x {b: c}\nd: *x":     `{"a":{"b":"c"},"d":{"b":"c"}}`,
+	}
+	for yaml, expected := range tests {
+		data, err := yamlToJSON([]byte(yaml))
+		assert.NoError(t, err, yaml)
+		assert.JSONEq(t, expected, string(data), yaml)
+	}
+}
+
+func TestEmbedManifest(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"config.json": `{"key":"value"}`,
+	})
+	content := "inline content"
+	m := &Manifest{
+		Version: 1,
+		Attachments: []ManifestEntry{
+			{Name: "config.json", Path: filepath.Join(dir, "config.json"), Compression: CompressionGzip, Mode: "0600"},
+			{Name: "inline.html", Content: &content, Labels: map[string]string{"k": "v"}},
+			{Name: "missing", Path: filepath.Join(dir, "missing"), Optional: true},
+		},
+	}
+
+	var out bytes.Buffer
+	err := EmbedManifest(&out, strings.NewReader(prepareExecutableData()), m, nil, WithCompression(CompressionZstd))
+	assert.NoError(t, err)
+
+	b, err := Inspect(bytes.NewReader(out.Bytes()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, b.Attachments(), 2)
+	info, _ := b.Attachment("config.json")
+	assert.Equal(t, CompressionGzip, info.Compression)
+	assert.Equal(t, fs.FileMode(0600), info.Metadata.Mode)
+	assert.Equal(t, "application/json", info.Metadata.ContentType)
+	info, _ = b.Attachment("inline.html")
+	assert.Equal(t, CompressionZstd, info.Compression)
+	assert.Equal(t, "text/html; charset=utf-8", info.Metadata.ContentType)
+	assert.Equal(t, map[string]string{"k": "v"}, info.Metadata.Labels)
+
+	att, err := ember.OpenExe(writeTempFile(t, out.Bytes()))
+	assert.NoError(t, err)
+	defer att.Close()
+	data, err := io.ReadAll(att.Reader("inline.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// required files must exist
+	m.Attachments[2].Optional = false
+	err = EmbedManifest(&out, strings.NewReader(prepareExecutableData()), m, nil)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
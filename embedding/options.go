@@ -0,0 +1,266 @@
+package embedding
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/maja42/ember/internal"
+)
+
+// Option configures the embedding process.
+type Option func(*config)
+
+type config struct {
+	signingKey ed25519.PrivateKey
+	unsigned   bool // remove the signature of existing attachments
+
+	defaultCompression Compression
+	compression        map[string]Compression // attachment name -> codec
+
+	defaultEncryption *encryptionKey
+	encryption        map[string]*encryptionKey // attachment name -> key
+
+	metadata map[string]Metadata // attachment name -> metadata
+
+	tombstones []string // attachments removed from previous layers
+
+	legacyTOC bool // write the JSON TOC of older ember versions
+
+	fileModTimes bool // store the modification times of embedded files
+
+	spoolLimit *int64 // number of bytes buffered in memory for non-seekable readers; nil for the default
+}
+
+// encryptionKey is used for encrypting attachments.
+type encryptionKey struct {
+	id  string
+	key []byte
+}
+
+func applyOptions(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithSigningKey signs the embedded attachments with the given Ed25519 private key.
+// The signature covers the TOC, including the names, sizes and SHA-256 digests of all attachments.
+// The application can verify the signature by opening its attachments with ember.WithTrustedKeys.
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(c *config) {
+		c.signingKey = key
+	}
+}
+
+// WithoutSigning allows Update to write unsigned output, even though the existing attachments are signed.
+// Without this option, Update fails with ErrSigned unless WithSigningKey is used.
+func WithoutSigning() Option {
+	return func(c *config) {
+		c.unsigned = true
+	}
+}
+
+// Compression defines how attachments are compressed within the executable.
+type Compression string
+
+// Supported compression codecs.
+const (
+	CompressionNone Compression = internal.CompressionNone
+	CompressionGzip Compression = internal.CompressionGzip
+	CompressionZstd Compression = internal.CompressionZstd
+)
+
+// WithCompression compresses the given attachments with the chosen codec.
+// If no names are given, the codec is used for all attachments that are not configured otherwise.
+//
+// The application decompresses attachments transparently.
+func WithCompression(codec Compression, names ...string) Option {
+	return func(c *config) {
+		if len(names) == 0 {
+			c.defaultCompression = codec
+			return
+		}
+		if c.compression == nil {
+			c.compression = make(map[string]Compression)
+		}
+		for _, name := range names {
+			c.compression[name] = codec
+		}
+	}
+}
+
+// compressionFor returns the compression codec for a specific attachment.
+func (c *config) compressionFor(name string) string {
+	if codec, ok := c.compression[name]; ok {
+		return string(codec)
+	}
+	return string(c.defaultCompression)
+}
+
+// WithEncryption encrypts the given attachments with AES-256-GCM.
+// If no names are given, the key is used for all attachments that are not configured otherwise.
+//
+// key must be 32 bytes long. keyID is stored alongside the attachment, and is passed to the application's
+// ember.KeyProvider to select the correct key for decryption. It must not contain any secret information.
+//
+// Encryption is applied after compression. Instead of a plain SHA-256 digest,
+// encrypted attachments contain a keyed digest (HMAC-SHA256) to not leak information about their content.
+func WithEncryption(keyID string, key []byte, names ...string) Option {
+	return func(c *config) {
+		k := &encryptionKey{id: keyID, key: key}
+		if len(names) == 0 {
+			c.defaultEncryption = k
+			return
+		}
+		if c.encryption == nil {
+			c.encryption = make(map[string]*encryptionKey)
+		}
+		for _, name := range names {
+			c.encryption[name] = k
+		}
+	}
+}
+
+// encryptionFor returns the encryption key for a specific attachment.
+// Returns nil if the attachment should not be encrypted.
+func (c *config) encryptionFor(name string) *encryptionKey {
+	if key, ok := c.encryption[name]; ok {
+		return key
+	}
+	return c.defaultEncryption
+}
+
+// keyFor returns the raw encryption key for a specific attachment, or nil.
+func (c *config) keyFor(name string) []byte {
+	if key := c.encryptionFor(name); key != nil {
+		return key.key
+	}
+	return nil
+}
+
+// Metadata contains optional information about an attachment.
+// Zero values are not stored.
+type Metadata struct {
+	Mode        fs.FileMode       // File mode; only permission bits are stored
+	ModTime     time.Time         // Modification time
+	ContentType string            // MIME type
+	Labels      map[string]string // Arbitrary key-value pairs
+}
+
+// WithMetadata stores additional information about an attachment.
+// When used multiple times for the same attachment, non-zero fields override previous values,
+// and labels are merged.
+//
+// EmbedFiles populates mode and content type automatically (and the modification time, see WithFileModTimes).
+// Content types are only stored for common file extensions, so the output does not depend on the system's MIME types.
+func WithMetadata(name string, meta Metadata) Option {
+	return func(c *config) {
+		if c.metadata == nil {
+			c.metadata = make(map[string]Metadata)
+		}
+		c.metadata[name] = c.metadata[name].merge(meta)
+	}
+}
+
+// merge returns a copy of m, with all non-zero values of other applied.
+func (m Metadata) merge(other Metadata) Metadata {
+	if other.Mode != 0 {
+		m.Mode = other.Mode
+	}
+	if !other.ModTime.IsZero() {
+		m.ModTime = other.ModTime
+	}
+	if other.ContentType != "" {
+		m.ContentType = other.ContentType
+	}
+	if len(other.Labels) > 0 {
+		labels := make(map[string]string, len(m.Labels)+len(other.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		for k, v := range other.Labels {
+			labels[k] = v
+		}
+		m.Labels = labels
+	}
+	return m
+}
+
+// apply stores the metadata in the TOC entry.
+func (m Metadata) apply(att *internal.Attachment) {
+	att.Mode = uint32(m.Mode.Perm())
+	if !m.ModTime.IsZero() {
+		att.ModTime = m.ModTime.UnixNano()
+	}
+	att.ContentType = m.ContentType
+	if len(m.Labels) > 0 {
+		att.Labels = m.Labels
+	}
+}
+
+// WithFileModTimes stores the modification times of files embedded via EmbedFiles.
+// They are omitted by default, so that embedding the same files always produces identical output.
+//
+// If the SOURCE_DATE_EPOCH environment variable is set, later modification times are clamped to it.
+func WithFileModTimes() Option {
+	return func(c *config) {
+		c.fileModTimes = true
+	}
+}
+
+// WithSpoolLimit limits the total number of bytes buffered in memory for attachments read from non-seekable sources
+// (see EmbedReaders). Once exceeded, attachments are buffered in temporary files.
+// A negative limit always uses temporary files. Defaults to DefaultSpoolLimit.
+func WithSpoolLimit(bytes int64) Option {
+	return func(c *config) {
+		c.spoolLimit = &bytes
+	}
+}
+
+// WithTombstones removes the given attachments of previous layers.
+// It can only be used with EmbedLayer.
+func WithTombstones(names ...string) Option {
+	return func(c *config) {
+		c.tombstones = append(c.tombstones, names...)
+	}
+}
+
+// WithLegacyTOC writes the TOC in the JSON format of older ember versions, instead of the binary format.
+// This allows applications built with older versions of ember to read their attachments,
+// but prevents them from using the name index of the binary format.
+//
+// Encryption, metadata and tombstones are not understood by older versions.
+func WithLegacyTOC() Option {
+	return func(c *config) {
+		c.legacyTOC = true
+	}
+}
+
+// validate ensures that the configuration is valid.
+func (c *config) validate() error {
+	if c.unsigned && c.signingKey != nil {
+		return errors.New("WithSigningKey and WithoutSigning are mutually exclusive")
+	}
+	if !internal.IsSupportedCompression(string(c.defaultCompression)) {
+		return fmt.Errorf("unsupported compression %q", c.defaultCompression)
+	}
+	for name, codec := range c.compression {
+		if !internal.IsSupportedCompression(string(codec)) {
+			return fmt.Errorf("attachment %q: unsupported compression %q", name, codec)
+		}
+	}
+	if c.defaultEncryption != nil && len(c.defaultEncryption.key) != internal.KeySize {
+		return fmt.Errorf("invalid encryption key size (expected %d bytes)", internal.KeySize)
+	}
+	for name, key := range c.encryption {
+		if len(key.key) != internal.KeySize {
+			return fmt.Errorf("attachment %q: invalid encryption key size (expected %d bytes)", name, internal.KeySize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package embedding
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the name of files listing patterns of files to skip when collecting directories (see Source).
+const IgnoreFile = ".emberignore"
+
+// SymlinkPolicy defines how symbolic links are handled when collecting directories.
+type SymlinkPolicy int
+
+const (
+	SymlinkFollow SymlinkPolicy = iota // Collect the link target; links to directories are walked recursively
+	SymlinkSkip                        // Ignore symbolic links
+	SymlinkError                       // Fail if a symbolic link is encountered
+)
+
+// Source describes a set of files to embed (see CollectFiles).
+//
+// Patterns use the syntax of path.Match, with '/' as separator. Additionally, "**" matches any number
+// of directories. Patterns containing a '/' are matched against the path relative to the source,
+// others against the file or directory name only.
+type Source struct {
+	// Path is a file, a directory or a glob pattern (see filepath.Match).
+	// Directories are collected recursively. Attachment names are based on the path relative to the directory;
+	// for glob patterns, relative to the directory of the pattern, and for files, the file name.
+	Path string
+
+	Include []string // If set, only files matching at least one pattern are collected
+	Exclude []string // Files and directories matching any pattern are skipped
+
+	Strip  string // Leading directory that is removed from relative paths, if present
+	Prefix string // Directory that is prepended to the attachment names
+
+	// Symlinks defines how symbolic links within directories are handled.
+	// Symbolic links given as Path, or matched by it, are always followed.
+	Symlinks SymlinkPolicy
+}
+
+// CollectFiles returns the files of all sources, mapping attachment names to file paths.
+// The result can be passed to EmbedFiles.
+//
+// Directories can contain an IgnoreFile. Each line is a pattern of files and directories to skip,
+// relative to the directory of the ignore file. Lines starting with '#' are comments;
+// patterns ending with '/' only match directories. Negation ('!') is not supported.
+// Ignore files are never collected.
+//
+// Fails if multiple files result in the same attachment name, or if a glob pattern does not match anything.
+func CollectFiles(sources ...Source) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, src := range sources {
+		if err := src.validate(); err != nil {
+			return nil, err
+		}
+		c := &collector{src: &src, files: files}
+		if err := c.collect(); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// validate ensures that all patterns are well-formed.
+func (s *Source) validate() error {
+	for _, pattern := range append(append([]string{}, s.Include...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("source %q: invalid pattern %q: %w", s.Path, pattern, err)
+		}
+	}
+	switch s.Symlinks {
+	case SymlinkFollow, SymlinkSkip, SymlinkError:
+		return nil
+	default:
+		return fmt.Errorf("source %q: invalid symlink policy %d", s.Path, s.Symlinks)
+	}
+}
+
+// collector gathers the files of a single source.
+type collector struct {
+	src       *Source
+	files     map[string]string // attachment name -> path
+	ancestors []string          // real paths of the directories currently walked, to detect symlink cycles
+}
+
+func (c *collector) collect() error {
+	if !hasMeta(c.src.Path) {
+		return c.collectRoot(c.src.Path, "")
+	}
+
+	matches, err := filepath.Glob(c.src.Path)
+	if err != nil {
+		return fmt.Errorf("source %q: %w", c.src.Path, err)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("source %q: no matching files", c.src.Path)
+	}
+	base := globBase(c.src.Path)
+	for _, match := range matches {
+		rel, err := filepath.Rel(base, match)
+		if err != nil {
+			return err
+		}
+		if err := c.collectRoot(match, filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectRoot collects a file or directory given by the source.
+// rel is its path relative to the source, which is empty for the source itself.
+func (c *collector) collectRoot(p, rel string) error {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return fmt.Errorf("source %q: %w", c.src.Path, err)
+	}
+	if !stat.IsDir() {
+		if rel == "" {
+			rel = filepath.Base(p)
+		}
+		if c.excluded(rel, nil, false) {
+			return nil
+		}
+		return c.add(p, rel, stat)
+	}
+	if rel != "" && c.excluded(rel, nil, true) {
+		return nil
+	}
+	return c.walk(p, rel, nil)
+}
+
+// walk collects the content of a directory.
+// rules are the ignore rules of all parent directories.
+func (c *collector) walk(dir, rel string, rules []ignoreRule) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range c.ancestors {
+		if ancestor == real {
+			return fmt.Errorf("source %q: %q: symbolic link cycle", c.src.Path, dir)
+		}
+	}
+	c.ancestors = append(c.ancestors, real)
+	defer func() { c.ancestors = c.ancestors[:len(c.ancestors)-1] }()
+
+	ignore, err := readIgnoreFile(filepath.Join(dir, IgnoreFile), rel)
+	if err != nil {
+		return fmt.Errorf("source %q: %w", c.src.Path, err)
+	}
+	rules = append(rules[:len(rules):len(rules)], ignore...)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("source %q: %w", c.src.Path, err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == IgnoreFile {
+			continue
+		}
+		p := filepath.Join(dir, entry.Name())
+		r := path.Join(rel, entry.Name())
+
+		stat, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("source %q: %w", c.src.Path, err)
+		}
+		if stat.Mode()&fs.ModeSymlink != 0 {
+			switch c.src.Symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkError:
+				return fmt.Errorf("source %q: %q: symbolic links are not allowed", c.src.Path, p)
+			}
+			if stat, err = os.Stat(p); err != nil {
+				return fmt.Errorf("source %q: %w", c.src.Path, err)
+			}
+		}
+
+		if c.excluded(r, rules, stat.IsDir()) {
+			continue
+		}
+		if stat.IsDir() {
+			err = c.walk(p, r, rules)
+		} else {
+			err = c.add(p, r, stat)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add collects a file with the given path relative to the source, unless it is not included.
+func (c *collector) add(p, rel string, stat fs.FileInfo) error {
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("source %q: %q: not a regular file", c.src.Path, p)
+	}
+	if len(c.src.Include) > 0 && !matchAny(c.src.Include, rel) {
+		return nil
+	}
+
+	name := rel
+	if strip := strings.Trim(path.Clean("/"+filepath.ToSlash(c.src.Strip)), "/"); strip != "" {
+		name = strings.TrimPrefix(name, strip+"/")
+	}
+	if c.src.Prefix != "" {
+		name = path.Join(filepath.ToSlash(c.src.Prefix), name)
+	}
+
+	if other, ok := c.files[name]; ok {
+		return fmt.Errorf("attachment %q: provided by %q and %q", name, other, p)
+	}
+	c.files[name] = p
+	return nil
+}
+
+// excluded checks if a file or directory with the given path relative to the source is skipped.
+func (c *collector) excluded(rel string, rules []ignoreRule, isDir bool) bool {
+	if matchAny(c.src.Exclude, rel) {
+		return true
+	}
+	for _, rule := range rules {
+		if rule.matches(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is a pattern read from an IgnoreFile.
+type ignoreRule struct {
+	dir     string // path of the directory containing the ignore file, relative to the source
+	pattern string
+	dirOnly bool
+}
+
+func (r *ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "" {
+		if !strings.HasPrefix(rel, r.dir+"/") {
+			return false
+		}
+		rel = rel[len(r.dir)+1:]
+	}
+	return matchPattern(r.pattern, rel)
+}
+
+// readIgnoreFile parses an IgnoreFile. Returns nil if the file does not exist.
+// dir is the path of its directory relative to the source.
+func readIgnoreFile(p, dir string) ([]ignoreRule, error) {
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			return nil, fmt.Errorf("%s:%d: negation is not supported", p, line)
+		}
+		rule := ignoreRule{dir: dir}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		rule.pattern = pattern
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %w", p, line, pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// matchAny checks if the path matches any of the patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern checks if a '/'-separated path matches the pattern.
+// Patterns without '/' are matched against the last path element only,
+// others against the whole path (a leading '/' has no further meaning).
+func matchPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path elements against pattern elements, where "**" matches any number of elements.
+func matchSegments(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchSegments(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// hasMeta reports whether the path contains any of the magic characters recognized by filepath.Match.
+func hasMeta(p string) bool {
+	magic := `*?[`
+	if filepath.Separator != '\\' {
+		magic = `*?[\`
+	}
+	return strings.ContainsAny(p, magic)
+}
+
+// globBase returns the leading directory of a glob pattern that does not contain magic characters.
+func globBase(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for hasMeta(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
@@ -0,0 +1,181 @@
+package embedding
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTree creates the given files (relative path -> content) within a temporary directory.
+func createTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+// relPaths converts the collected file paths to be relative to dir.
+func relPaths(t *testing.T, dir string, files map[string]string) map[string]string {
+	rel := make(map[string]string, len(files))
+	for name, p := range files {
+		r, err := filepath.Rel(dir, p)
+		assert.NoError(t, err)
+		rel[name] = filepath.ToSlash(r)
+	}
+	return rel
+}
+
+func TestCollectFiles(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"assets/index.html":         "",
+		"assets/img/logo.png":       "",
+		"assets/img/raw/logo.psd":   "",
+		"assets/js/app.js":          "",
+		"assets/js/app.js.map":      "",
+		"assets/node_modules/x.js":  "",
+		"assets/build/out.bin":      "",
+		"assets/sub/build/keep.txt": "",
+		"assets/.emberignore":       "# generated\n/build/\n*.map\n",
+		"config.json":               "",
+	})
+
+	tests := map[string]struct {
+		sources  []Source
+		expected map[string]string
+	}{
+		"directory": {
+			sources: []Source{{Path: filepath.Join(dir, "assets"), Exclude: []string{"node_modules"}}},
+			expected: map[string]string{
+				"index.html":         "assets/index.html",
+				"img/logo.png":       "assets/img/logo.png",
+				"img/raw/logo.psd":   "assets/img/raw/logo.psd",
+				"js/app.js":          "assets/js/app.js",
+				"sub/build/keep.txt": "assets/sub/build/keep.txt",
+			},
+		},
+		"include": {
+			sources: []Source{{Path: filepath.Join(dir, "assets"), Include: []string{"*.png", "js/**"}}},
+			expected: map[string]string{
+				"img/logo.png": "assets/img/logo.png",
+				"js/app.js":    "assets/js/app.js",
+			},
+		},
+		"exclude path": {
+			sources: []Source{{Path: filepath.Join(dir, "assets"), Include: []string{"img/**"}, Exclude: []string{"img/raw"}}},
+			expected: map[string]string{
+				"img/logo.png": "assets/img/logo.png",
+			},
+		},
+		"prefix and strip": {
+			sources: []Source{{Path: filepath.Join(dir, "assets"), Include: []string{"img/**"}, Strip: "img", Prefix: "static"}},
+			expected: map[string]string{
+				"static/logo.png":     "assets/img/logo.png",
+				"static/raw/logo.psd": "assets/img/raw/logo.psd",
+			},
+		},
+		"glob": {
+			sources: []Source{{Path: filepath.Join(dir, "assets", "*", "*.png")}},
+			expected: map[string]string{
+				"img/logo.png": "assets/img/logo.png",
+			},
+		},
+		"file": {
+			sources: []Source{
+				{Path: filepath.Join(dir, "config.json")},
+				{Path: filepath.Join(dir, "assets", "index.html"), Prefix: "web"},
+			},
+			expected: map[string]string{
+				"config.json":    "config.json",
+				"web/index.html": "assets/index.html",
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			files, err := CollectFiles(test.sources...)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, relPaths(t, dir, files))
+		})
+	}
+}
+
+func TestCollectFiles_errors(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"a/file.txt":     "",
+		"b/file.txt":     "",
+		"c/.emberignore": "!keep\n",
+	})
+
+	_, err := CollectFiles(Source{Path: filepath.Join(dir, "a")}, Source{Path: filepath.Join(dir, "b")})
+	assert.ErrorContains(t, err, `attachment "file.txt": provided by`)
+
+	_, err = CollectFiles(Source{Path: filepath.Join(dir, "*.none")})
+	assert.ErrorContains(t, err, "no matching files")
+
+	_, err = CollectFiles(Source{Path: dir, Include: []string{"["}})
+	assert.ErrorContains(t, err, `invalid pattern "["`)
+
+	_, err = CollectFiles(Source{Path: filepath.Join(dir, "c")})
+	assert.ErrorContains(t, err, "negation is not supported")
+
+	_, err = CollectFiles(Source{Path: filepath.Join(dir, "missing")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCollectFiles_symlinks(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"src/file.txt":    "",
+		"target/data.txt": "",
+	})
+	if err := os.Symlink(filepath.Join(dir, "target"), filepath.Join(dir, "src", "linked")); err != nil {
+		t.Skipf("symbolic links not supported: %s", err)
+	}
+	src := Source{Path: filepath.Join(dir, "src")}
+
+	files, err := CollectFiles(src)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"file.txt":        "src/file.txt",
+		"linked/data.txt": "src/linked/data.txt",
+	}, relPaths(t, dir, files))
+
+	src.Symlinks = SymlinkSkip
+	files, err = CollectFiles(src)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"file.txt": "src/file.txt"}, relPaths(t, dir, files))
+
+	src.Symlinks = SymlinkError
+	_, err = CollectFiles(src)
+	assert.ErrorContains(t, err, "symbolic links are not allowed")
+
+	// cycles are detected
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "target"), filepath.Join(dir, "target", "loop")))
+	_, err = CollectFiles(Source{Path: filepath.Join(dir, "target")})
+	assert.ErrorContains(t, err, "symbolic link cycle")
+}
+
+func Test_matchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"*.png", "logo.png", true},
+		{"*.png", "img/logo.png", true},
+		{"img/*.png", "img/logo.png", true},
+		{"img/*.png", "a/img/logo.png", false},
+		{"/img/*.png", "img/logo.png", true},
+		{"**/*.png", "logo.png", true},
+		{"**/*.png", "a/b/logo.png", true},
+		{"img/**", "img/a/b.png", true},
+		{"img/**", "other/a.png", false},
+		{"a/**/b", "a/x/y/b", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.match, matchPattern(test.pattern, test.path), "%s ~ %s", test.pattern, test.path)
+	}
+}
@@ -0,0 +1,112 @@
+package embedding
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpoolLimit is the default number of bytes buffered in memory for attachments read from non-seekable sources.
+const DefaultSpoolLimit = 32 << 20
+
+// EmbedReaders embeds attachments read from arbitrary readers, like pipes or HTTP response bodies.
+//
+// Embedding requires reading each attachment twice (to measure it and to write it).
+// Readers that do not support seeking are therefore buffered: up to the spool limit in memory (see WithSpoolLimit),
+// and in temporary files afterwards. The temporary files are removed before returning.
+// Readers that support seeking are used directly, and are seeked to their start.
+//
+// See Embed for more information.
+func EmbedReaders(out io.Writer, exe io.ReadSeeker, attachments map[string]io.Reader, logger PrintlnFunc, opts ...Option) error {
+	return withReaders(attachments, opts, func(reader map[string]io.ReadSeeker) error {
+		return Embed(out, exe, reader, logger, opts...)
+	})
+}
+
+// EmbedLayerReaders appends a new layer with attachments read from arbitrary readers.
+//
+// See EmbedReaders and EmbedLayer for more information.
+func EmbedLayerReaders(out io.Writer, exe io.ReadSeeker, attachments map[string]io.Reader, logger PrintlnFunc, opts ...Option) error {
+	return withReaders(attachments, opts, func(reader map[string]io.ReadSeeker) error {
+		return EmbedLayer(out, exe, reader, logger, opts...)
+	})
+}
+
+// withReaders spools the given readers and passes them to fn.
+// Temporary files are removed afterwards.
+func withReaders(attachments map[string]io.Reader, opts []Option, fn func(map[string]io.ReadSeeker) error) error {
+	s := newSpooler(applyOptions(opts))
+	defer s.close()
+
+	reader := make(map[string]io.ReadSeeker, len(attachments))
+	for name, r := range attachments {
+		rs, err := s.spool(r)
+		if err != nil {
+			return fmt.Errorf("read attachment %q: %w", name, err)
+		}
+		reader[name] = rs
+	}
+	return fn(reader)
+}
+
+// spooler buffers the content of non-seekable readers, so it can be read multiple times.
+type spooler struct {
+	budget int64      // remaining number of bytes that can be buffered in memory
+	files  []*os.File // temporary files
+}
+
+func newSpooler(c *config) *spooler {
+	budget := int64(DefaultSpoolLimit)
+	if c.spoolLimit != nil {
+		budget = *c.spoolLimit
+	}
+	return &spooler{budget: budget}
+}
+
+// spool returns a seekable reader with the content of r.
+func (s *spooler) spool(r io.Reader) (io.ReadSeeker, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		// eg. pipes are files, but can not be seeked
+		if _, err := rs.Seek(0, io.SeekStart); err == nil {
+			return rs, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if s.budget >= 0 {
+		n, err := io.Copy(&buf, io.LimitReader(r, s.budget+1))
+		if err != nil {
+			return nil, err
+		}
+		if n <= s.budget {
+			s.budget -= n
+			return bytes.NewReader(buf.Bytes()), nil
+		}
+	}
+
+	file, err := os.CreateTemp("", "ember-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, file)
+	if _, err := io.Copy(file, io.MultiReader(&buf, r)); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// close removes all temporary files.
+func (s *spooler) close() error {
+	var firstErr error
+	for _, file := range s.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := os.Remove(file.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.files = nil
+	return firstErr
+}
@@ -0,0 +1,126 @@
+package embedding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+// nonSeekable hides the Seek method of the underlying reader.
+type nonSeekable struct {
+	io.Reader
+}
+
+// errReader fails all reads.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("simulated error")
+}
+
+func TestEmbedReaders(t *testing.T) {
+	contents := map[string]string{
+		"small":    "small content",
+		"large":    strings.Repeat("large content ", 100),
+		"seekable": "seekable content",
+		"empty":    "",
+	}
+
+	for _, limit := range []int64{DefaultSpoolLimit, 100, 0, -1} {
+		attachments := map[string]io.Reader{
+			"small":    nonSeekable{strings.NewReader(contents["small"])},
+			"large":    nonSeekable{strings.NewReader(contents["large"])},
+			"seekable": strings.NewReader(contents["seekable"]),
+			"empty":    nonSeekable{strings.NewReader("")},
+		}
+
+		var out bytes.Buffer
+		exe := prepareExecutableData()
+		err := EmbedReaders(&out, strings.NewReader(exe), attachments, nil, WithSpoolLimit(limit))
+		assert.NoError(t, err)
+
+		att, err := ember.OpenExe(writeTempFile(t, out.Bytes()))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for name, expected := range contents {
+			content, err := io.ReadAll(att.Reader(name))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		}
+		assert.NoError(t, att.VerifyAll())
+		assert.NoError(t, att.Close())
+	}
+}
+
+func TestEmbedReaders_readError(t *testing.T) {
+	attachments := map[string]io.Reader{
+		"broken": io.MultiReader(strings.NewReader("data"), errReader{}),
+	}
+	var out bytes.Buffer
+	err := EmbedReaders(&out, strings.NewReader(prepareExecutableData()), attachments, nil)
+	assert.EqualError(t, err, `read attachment "broken": simulated error`)
+}
+
+func Test_spooler(t *testing.T) {
+	limit := int64(10)
+	s := newSpooler(&config{spoolLimit: &limit})
+
+	r, err := s.spool(nonSeekable{strings.NewReader("12345")})
+	assert.NoError(t, err)
+	assert.IsType(t, &bytes.Reader{}, r)
+	assert.Equal(t, int64(5), s.budget)
+
+	r, err = s.spool(nonSeekable{strings.NewReader("123456")}) // exceeds the remaining budget
+	assert.NoError(t, err)
+	assert.IsType(t, &os.File{}, r)
+	assert.Len(t, s.files, 1)
+	_, err = r.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456", string(content))
+
+	path := s.files[0].Name()
+	assert.NoError(t, s.close())
+	assert.NoFileExists(t, path)
+}
+
+func TestEmbedFiles_stdin(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	assert.NoError(t, err)
+	stdin := os.Stdin
+	os.Stdin = pr
+	defer func() { os.Stdin = stdin }()
+
+	go func() {
+		_, _ = pw.WriteString(`{"generated":true}`)
+		_ = pw.Close()
+	}()
+
+	var out bytes.Buffer
+	err = EmbedFiles(&out, strings.NewReader(prepareExecutableData()), map[string]string{
+		"config.json": StdinPath,
+	}, nil)
+	assert.NoError(t, err)
+
+	att, err := ember.OpenExe(writeTempFile(t, out.Bytes()))
+	assert.NoError(t, err)
+	defer att.Close()
+	content, err := io.ReadAll(att.Reader("config.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"generated":true}`, string(content))
+	assert.Equal(t, "application/json", att.ContentType("config.json"))
+
+	err = EmbedFiles(&out, strings.NewReader(prepareExecutableData()), map[string]string{
+		"a": StdinPath,
+		"b": StdinPath,
+	}, nil)
+	assert.ErrorContains(t, err, "standard input can only be embedded once")
+}
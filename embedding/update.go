@@ -0,0 +1,208 @@
+package embedding
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/maja42/ember/internal"
+)
+
+// ErrSigned is returned by Update if the existing attachments are signed,
+// but neither WithSigningKey nor WithoutSigning is used.
+var ErrSigned = errors.New("existing attachments are signed (use WithSigningKey to sign the result, or WithoutSigning to remove the signature)")
+
+// Changes describes modifications of the attachments of an augmented executable (see Update).
+// Each attachment name can only be used by a single operation.
+type Changes struct {
+	Add     map[string]io.ReadSeeker // New attachments; must not exist yet
+	Replace map[string]io.ReadSeeker // New content for existing attachments
+	Rename  map[string]string        // Existing attachments to rename (old name -> new name); the new name must not exist yet
+	Delete  []string                 // Existing attachments to remove
+}
+
+// Update modifies the attachments of an executable that already contains attachments.
+//
+// Attachments that are not replaced or deleted are copied byte-for-byte from the source executable,
+// without being decompressed, decrypted or re-encoded. This includes renamed attachments.
+// Their content therefore does not need to be available on disk.
+//
+// All changes are validated before writing any output. Update fails if an operation references an attachment
+// that does not exist (or one that already exists, for additions and rename targets),
+// or if an attachment name is used by multiple operations.
+//
+// The output contains a single layer with the resulting attachments; previous layers are merged.
+// Data appended to the source executable after its attachments is not preserved.
+//
+// opts apply to added and replaced attachments, and are used for signing the result.
+// If any layer of the source executable is signed, either WithSigningKey or WithoutSigning is required,
+// so that signatures are not removed by accident. Otherwise, ErrSigned is returned.
+// Tombstones are not supported; use Changes.Delete instead.
+// Returns ErrNothingEmbedded if the executable does not contain attachments.
+//
+// See Embed for more information.
+func Update(out io.Writer, exe io.ReadSeeker, changes Changes, logger PrintlnFunc, opts ...Option) error {
+	if logger == nil {
+		logger = func(string, ...interface{}) {}
+	}
+	cfg := applyOptions(opts)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if len(cfg.tombstones) > 0 {
+		return errors.New("tombstones are not supported when updating attachments")
+	}
+
+	size, err := exe.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	existing, err := inspect(&internal.ReadSeekerAt{RS: exe}, size)
+	if err != nil {
+		return err
+	}
+	if existing.signed() && cfg.signingKey == nil && !cfg.unsigned {
+		return ErrSigned
+	}
+	if err := changes.validate(existing); err != nil {
+		return err
+	}
+
+	attachments := make(map[string]io.ReadSeeker, len(changes.Add)+len(changes.Replace))
+	for name, r := range changes.Add {
+		attachments[name] = r
+	}
+	for name, r := range changes.Replace {
+		attachments[name] = r
+	}
+	removed := make(map[string]bool, len(changes.Delete))
+	for _, name := range changes.Delete {
+		removed[name] = true
+	}
+
+	stored := make(map[string]storedAttachment, len(existing.entries))
+	for _, e := range existing.entries {
+		name := e.Name
+		if _, replaced := changes.Replace[name]; replaced || removed[name] {
+			continue
+		}
+		if newName, ok := changes.Rename[name]; ok {
+			logger("Renaming %q to %q", name, newName)
+			e.Name = newName
+		}
+		stored[e.Name] = storedAttachment{
+			Attachment: e.Attachment,
+			data:       io.NewSectionReader(existing.exe, e.offset, e.Stored()),
+		}
+	}
+	for _, name := range changes.Delete {
+		logger("Removing %q", name)
+	}
+
+	if _, err := exe.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	original := io.LimitReader(exe, existing.ExeSize)
+	return embed(out, original, nil, attachments, stored, logger, cfg)
+}
+
+// FileChanges describes modifications of the attachments of an augmented executable (see UpdateFiles).
+// New content is read from files; see Changes for more information.
+type FileChanges struct {
+	Add     map[string]string // New attachments (attachment name -> file path); must not exist yet
+	Replace map[string]string // New content for existing attachments (attachment name -> file path)
+	Rename  map[string]string // Existing attachments to rename (old name -> new name); the new name must not exist yet
+	Delete  []string          // Existing attachments to remove
+}
+
+// UpdateFiles modifies the attachments of an executable that already contains attachments,
+// reading added and replaced attachments from files.
+// Like with EmbedFiles, their file mode and content type are stored as metadata.
+//
+// See Update and EmbedFiles for more information.
+func UpdateFiles(out io.Writer, exe io.ReadSeeker, changes FileChanges, logger PrintlnFunc, opts ...Option) error {
+	files := make(map[string]string, len(changes.Add)+len(changes.Replace))
+	for name, path := range changes.Add {
+		files[name] = path
+	}
+	for name, path := range changes.Replace {
+		if _, ok := changes.Add[name]; ok {
+			return fmt.Errorf("attachment %q: conflicting operations (replace and add)", name)
+		}
+		files[name] = path
+	}
+	return withFiles(files, opts, func(reader map[string]io.ReadSeeker, opts []Option) error {
+		c := Changes{
+			Add:     make(map[string]io.ReadSeeker, len(changes.Add)),
+			Replace: make(map[string]io.ReadSeeker, len(changes.Replace)),
+			Rename:  changes.Rename,
+			Delete:  changes.Delete,
+		}
+		for name := range changes.Add {
+			c.Add[name] = reader[name]
+		}
+		for name := range changes.Replace {
+			c.Replace[name] = reader[name]
+		}
+		return Update(out, exe, c, logger, opts...)
+	})
+}
+
+// validate ensures that the changes can be applied to the existing attachments.
+func (c *Changes) validate(existing *Bundle) error {
+	used := make(map[string]string) // attachment name -> operation
+	use := func(name, op string, exists bool) error {
+		if name == "" {
+			return fmt.Errorf("%s: empty attachment name", op)
+		}
+		if prev, ok := used[name]; ok {
+			return fmt.Errorf("attachment %q: conflicting operations (%s and %s)", name, prev, op)
+		}
+		used[name] = op
+
+		_, ok := existing.index[name]
+		if exists && !ok {
+			return fmt.Errorf("attachment %q: %s: does not exist", name, op)
+		}
+		if !exists && ok {
+			return fmt.Errorf("attachment %q: %s: already exists", name, op)
+		}
+		return nil
+	}
+
+	for _, name := range c.Delete {
+		if err := use(name, "delete", true); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(c.Replace) {
+		if err := use(name, "replace", true); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(c.Rename) {
+		if err := use(name, "rename", true); err != nil {
+			return err
+		}
+		if err := use(c.Rename[name], "rename", false); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(c.Add) {
+		if err := use(name, "add", false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the map in lexical order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
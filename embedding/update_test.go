@@ -0,0 +1,240 @@
+package embedding
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareAugmented returns an executable containing the given attachments in two layers.
+func prepareAugmented(t *testing.T, base, layer map[string]string, opts ...Option) []byte {
+	readers := func(contents map[string]string) map[string]io.ReadSeeker {
+		r := make(map[string]io.ReadSeeker, len(contents))
+		for name, content := range contents {
+			r[name] = strings.NewReader(content)
+		}
+		return r
+	}
+
+	var first bytes.Buffer
+	err := Embed(&first, strings.NewReader(prepareExecutableData()), readers(base), nil, opts...)
+	assert.NoError(t, err)
+	if layer == nil {
+		return first.Bytes()
+	}
+	var second bytes.Buffer
+	err = EmbedLayer(&second, bytes.NewReader(first.Bytes()), readers(layer), nil, opts...)
+	assert.NoError(t, err)
+	return second.Bytes()
+}
+
+func TestUpdate(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	src := prepareAugmented(t, map[string]string{
+		"keep":    strings.Repeat("keep ", 50),
+		"replace": "old content",
+		"delete":  "deleted",
+		"old":     "renamed content",
+	}, map[string]string{
+		"layered": "from second layer",
+	}, WithCompression(CompressionGzip), WithEncryption("key", key))
+
+	var out bytes.Buffer
+	err := Update(&out, bytes.NewReader(src), Changes{
+		Add:     map[string]io.ReadSeeker{"added": strings.NewReader("added content")},
+		Replace: map[string]io.ReadSeeker{"replace": strings.NewReader("new content")},
+		Rename:  map[string]string{"old": "new"},
+		Delete:  []string{"delete"},
+	}, nil, WithCompression(CompressionZstd))
+	assert.NoError(t, err)
+
+	keys := ember.WithKeyProvider(ember.KeyProviderFunc(func(string) ([]byte, error) {
+		return key, nil
+	}))
+	before, err := ember.OpenExe(writeTempFile(t, src), keys)
+	assert.NoError(t, err)
+	defer before.Close()
+	after, err := ember.OpenExe(writeTempFile(t, out.Bytes()), keys)
+	assert.NoError(t, err)
+	defer after.Close()
+
+	assert.Equal(t, []string{"added", "keep", "layered", "new", "replace"}, after.List())
+	for name, expected := range map[string]string{
+		"added":   "added content",
+		"keep":    strings.Repeat("keep ", 50),
+		"layered": "from second layer",
+		"new":     "renamed content",
+		"replace": "new content",
+	} {
+		content, err := io.ReadAll(after.Reader(name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+	assert.NoError(t, after.VerifyAll())
+
+	// untouched attachments are copied byte-for-byte
+	stored := func(data []byte, att *ember.Attachments, name string) []byte {
+		offset := att.Offset(name)
+		return data[offset : offset+att.StoredSize(name)]
+	}
+	assert.Equal(t, stored(src, before, "keep"), stored(out.Bytes(), after, "keep"))
+	assert.Equal(t, stored(src, before, "old"), stored(out.Bytes(), after, "new"))
+
+	// the original executable is preserved
+	exe := prepareExecutableData()
+	assert.Equal(t, exe, string(out.Bytes()[:len(exe)]))
+}
+
+func TestUpdate_invalidChanges(t *testing.T) {
+	src := prepareAugmented(t, map[string]string{
+		"a": "a",
+		"b": "b",
+	}, nil)
+
+	tests := map[string]struct {
+		changes Changes
+		err     string
+	}{
+		"add existing": {
+			changes: Changes{Add: map[string]io.ReadSeeker{"a": strings.NewReader("")}},
+			err:     `attachment "a": add: already exists`,
+		},
+		"replace missing": {
+			changes: Changes{Replace: map[string]io.ReadSeeker{"c": strings.NewReader("")}},
+			err:     `attachment "c": replace: does not exist`,
+		},
+		"delete missing": {
+			changes: Changes{Delete: []string{"c"}},
+			err:     `attachment "c": delete: does not exist`,
+		},
+		"rename missing": {
+			changes: Changes{Rename: map[string]string{"c": "d"}},
+			err:     `attachment "c": rename: does not exist`,
+		},
+		"rename to existing": {
+			changes: Changes{Rename: map[string]string{"a": "b"}},
+			err:     `attachment "b": rename: already exists`,
+		},
+		"delete and replace": {
+			changes: Changes{
+				Delete:  []string{"a"},
+				Replace: map[string]io.ReadSeeker{"a": strings.NewReader("")},
+			},
+			err: `attachment "a": conflicting operations (delete and replace)`,
+		},
+		"rename and add": {
+			changes: Changes{
+				Rename: map[string]string{"a": "c"},
+				Add:    map[string]io.ReadSeeker{"c": strings.NewReader("")},
+			},
+			err: `attachment "c": conflicting operations (rename and add)`,
+		},
+		"delete twice": {
+			changes: Changes{Delete: []string{"a", "a"}},
+			err:     `attachment "a": conflicting operations (delete and delete)`,
+		},
+		"empty name": {
+			changes: Changes{Add: map[string]io.ReadSeeker{"": strings.NewReader("")}},
+			err:     `add: empty attachment name`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Update(&out, bytes.NewReader(src), test.changes, nil)
+			assert.EqualError(t, err, test.err)
+			assert.Zero(t, out.Len(), "no output is written")
+		})
+	}
+
+	t.Run("tombstones", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Update(&out, bytes.NewReader(src), Changes{}, nil, WithTombstones("a"))
+		assert.Error(t, err)
+	})
+
+	t.Run("nothing embedded", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Update(&out, strings.NewReader(prepareExecutableData()), Changes{}, nil)
+		assert.ErrorIs(t, err, ErrNothingEmbedded)
+	})
+}
+
+func TestUpdate_signed(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	src := prepareAugmented(t, map[string]string{"a": "a"}, nil, WithSigningKey(key))
+	changes := func() Changes {
+		return Changes{Add: map[string]io.ReadSeeker{"b": strings.NewReader("b")}}
+	}
+
+	var out bytes.Buffer
+	err = Update(&out, bytes.NewReader(src), changes(), nil)
+	assert.ErrorIs(t, err, ErrSigned)
+	assert.Zero(t, out.Len(), "no output is written")
+
+	err = Update(&out, bytes.NewReader(src), changes(), nil, WithSigningKey(key), WithoutSigning())
+	assert.Error(t, err)
+
+	out.Reset()
+	err = Update(&out, bytes.NewReader(src), changes(), nil, WithSigningKey(key))
+	assert.NoError(t, err)
+	bundle, err := Inspect(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), bundle.Layers[0].PublicKey)
+
+	out.Reset()
+	err = Update(&out, bytes.NewReader(src), changes(), nil, WithoutSigning())
+	assert.NoError(t, err)
+	bundle, err = Inspect(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Nil(t, bundle.Layers[0].PublicKey)
+}
+
+func TestUpdateFiles(t *testing.T) {
+	src := prepareAugmented(t, map[string]string{
+		"replace": "old content",
+		"keep":    "kept",
+	}, nil)
+
+	dir := t.TempDir()
+	added := filepath.Join(dir, "added.json")
+	assert.NoError(t, os.WriteFile(added, []byte(`{"added":true}`), 0640))
+	replaced := filepath.Join(dir, "replaced.txt")
+	assert.NoError(t, os.WriteFile(replaced, []byte("new content"), 0600))
+
+	var out bytes.Buffer
+	err := UpdateFiles(&out, bytes.NewReader(src), FileChanges{
+		Add:     map[string]string{"added.json": added},
+		Replace: map[string]string{"replace": replaced},
+	}, nil)
+	assert.NoError(t, err)
+
+	att, err := ember.OpenExe(writeTempFile(t, out.Bytes()))
+	assert.NoError(t, err)
+	defer att.Close()
+	assert.Equal(t, []string{"added.json", "keep", "replace"}, att.List())
+	content, err := io.ReadAll(att.Reader("replace"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+	assert.Equal(t, "application/json", att.ContentType("added.json"))
+
+	for name, mode := range map[string]os.FileMode{"added.json": 0640, "replace": 0600} {
+		stat, err := att.Stat(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, mode, stat.Mode().Perm(), name)
+		}
+	}
+
+	err = UpdateFiles(&out, bytes.NewReader(src), FileChanges{
+		Add: map[string]string{"missing": filepath.Join(dir, "missing")},
+	}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,15 +1,97 @@
 package ember
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/maja42/ember/internal"
+)
+
+// ErrCorrupt indicates that the attachment data was modified after embedding.
+// It is the category of all errors reporting invalid or tampered data, like ErrCorruptTOC, ErrChecksumMismatch,
+// ErrInvalidSignature or ErrDecryption.
+var ErrCorrupt = internal.ErrCorrupt
+
+// ErrTruncated indicates that the executable ends before all attachment data was read,
+// for example because it was not fully downloaded.
+// It is the category of all errors reporting missing data, like ErrIncompleteTOC.
+var ErrTruncated = errors.New("corrupt attachment data (truncated)")
+
+// Errors returned while parsing attachments.
+// Use errors.Is to check for them, and for their category (ErrCorrupt or ErrTruncated).
+var (
+	// ErrIncompleteTOC is returned if the start of the TOC was found, but not its end.
+	ErrIncompleteTOC error = internal.NewCategorizedErr("corrupt attachment data (incomplete TOC)", ErrTruncated)
+	// ErrCorruptTOC is returned if the TOC could not be parsed.
+	ErrCorruptTOC error = internal.NewCategorizedErr("corrupt attachment data (invalid TOC)", ErrCorrupt)
+	// ErrInvalidFooter is returned if the footer does not point to a valid TOC.
+	ErrInvalidFooter error = internal.NewCategorizedErr("corrupt attachment data (invalid footer)", ErrCorrupt)
+	// ErrInvalidOffsets is returned if the attachment data does not match the sizes stored in the TOC.
+	ErrInvalidOffsets error = internal.NewCategorizedErr("corrupt attachment data (invalid offsets)", ErrCorrupt)
+	// ErrUnsupported is returned if the attachments use features (like compression codecs) unknown to this version of ember.
+	ErrUnsupported = internal.ErrUnsupported
+	// ErrFormatTooNew is returned if the attachments were embedded by a newer, incompatible version of ember.
+	ErrFormatTooNew = internal.ErrFormatTooNew
+)
+
+// ErrNotFound is returned when accessing an attachment that does not exist.
+// It matches fs.ErrNotExist.
+var ErrNotFound error = internal.NewCategorizedErr("attachment not found", fs.ErrNotExist)
 
 // AttErr reports problems with embedded attachments.
-type AttErr string
+// It wraps the underlying error (usually one of the sentinel errors of this package),
+// and carries information about where the problem was detected.
+type AttErr struct {
+	Path   string // Path of the executable; empty if unknown (eg. when using NewReader)
+	Name   string // Name of the affected attachment; empty if the problem is not specific to one attachment
+	Offset int64  // Position within the executable where the problem was detected; negative if unknown
+	Err    error  // Underlying error
+}
+
+func (e *AttErr) Error() string {
+	var sb strings.Builder
+	if e.Path != "" {
+		sb.WriteString(e.Path + ": ")
+	}
+	if e.Name != "" {
+		fmt.Fprintf(&sb, "attachment %q: ", e.Name)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&sb, "offset %d: ", e.Offset)
+	}
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *AttErr) Unwrap() error {
+	return e.Err
+}
+
+func newAttErr(err error, name string, offset int64) *AttErr {
+	return &AttErr{
+		Name:   name,
+		Offset: offset,
+		Err:    err,
+	}
+}
 
-func (o *AttErr) Error() string {
-	return string(*o)
+// attErr reports a problem with the given attachment.
+func (a *Attachments) attErr(err error, e *entry) *AttErr {
+	return &AttErr{
+		Path:   a.path,
+		Name:   e.Name,
+		Offset: e.offset,
+		Err:    err,
+	}
 }
 
-func newAttErr(format string, a ...interface{}) *AttErr {
-	err := AttErr(fmt.Sprintf(format, a...))
-	return &err
+// withPath sets the executable path of an AttErr, if the given error contains one.
+func withPath(err error, path string) error {
+	var attErr *AttErr
+	if errors.As(err, &attErr) && attErr.Path == "" {
+		attErr.Path = path
+	}
+	return err
 }
@@ -0,0 +1,58 @@
+package ember
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttErr_Error(t *testing.T) {
+	err := &AttErr{Path: "/bin/app", Name: "att", Offset: 42, Err: ErrChecksumMismatch}
+	assert.EqualError(t, err, `/bin/app: attachment "att": offset 42: corrupt attachment data (checksum mismatch)`)
+
+	err = &AttErr{Offset: -1, Err: ErrCorruptTOC}
+	assert.EqualError(t, err, "corrupt attachment data (invalid TOC)")
+}
+
+func TestAttErr_Is(t *testing.T) {
+	err := &AttErr{Offset: -1, Err: ErrIncompleteTOC}
+	assert.ErrorIs(t, err, ErrIncompleteTOC)
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.NotErrorIs(t, err, ErrCorrupt)
+
+	err = &AttErr{Offset: -1, Err: ErrChecksumMismatch}
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.NotErrorIs(t, err, ErrTruncated)
+
+	assert.True(t, errors.Is(ErrNotFound, fs.ErrNotExist))
+	assert.True(t, errors.Is(ErrClosed, fs.ErrClosed))
+}
+
+func TestErrorCategories(t *testing.T) {
+	tests := []struct {
+		err       error
+		corrupt   bool
+		truncated bool
+	}{
+		{ErrIncompleteTOC, false, true},
+		{ErrCorruptTOC, true, false},
+		{ErrInvalidFooter, true, false},
+		{ErrInvalidOffsets, true, false},
+		{ErrChecksumMismatch, true, false},
+		{ErrInvalidSignature, true, false},
+		{ErrUnsigned, true, false},
+		{ErrDecryption, true, false},
+		{errTruncatedData, true, false},
+		{ErrNoDigest, false, false},
+		{ErrNotFound, false, false},
+		{ErrUnsupported, false, false},
+		{ErrFormatTooNew, false, false},
+	}
+	for _, test := range tests {
+		err := &AttErr{Offset: -1, Err: test.err}
+		assert.Equal(t, test.corrupt, errors.Is(err, ErrCorrupt), "%v is corrupt", test.err)
+		assert.Equal(t, test.truncated, errors.Is(err, ErrTruncated), "%v is truncated", test.err)
+	}
+}
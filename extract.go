@@ -0,0 +1,253 @@
+package ember
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsafeName is returned when extracting an attachment whose name is not a safe relative path,
+// for example because it is absolute, contains a drive letter or refers to a parent directory.
+var ErrUnsafeName = errors.New("unsafe attachment name")
+
+// ErrNameCollision is returned when extracting attachments whose names only differ in case,
+// or if an attachment name is also the parent directory of another attachment.
+// Such attachments can not be extracted on case-insensitive file systems.
+var ErrNameCollision = errors.New("colliding attachment names")
+
+// defaultExtractMode is the permission of extracted attachments that were embedded without file mode.
+const defaultExtractMode = 0644
+
+// ExtractOption configures the extraction of attachments.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	skipIdentical bool
+}
+
+// SkipIdentical skips attachments whose destination file already exists with identical content.
+// By default, existing files are always replaced.
+func SkipIdentical() ExtractOption {
+	return func(o *extractOptions) {
+		o.skipIdentical = true
+	}
+}
+
+func applyExtractOptions(opts []ExtractOption) *extractOptions {
+	o := &extractOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ExtractAll writes all attachments into the given directory.
+// Attachment names are treated as '/'-separated paths relative to dir; intermediate directories are created as needed.
+//
+// Before anything is written, all names are checked. Returns ErrUnsafeName if a name is absolute,
+// contains a drive letter or refers to a parent directory, and ErrNameCollision if names collide on
+// case-insensitive file systems.
+//
+// Each file is written to a temporary file first, which is renamed once it is complete.
+// Stored file modes and modification times are applied.
+// Attachments with a digest are verified during extraction.
+func (a *Attachments) ExtractAll(dir string, opts ...ExtractOption) error {
+	o := applyExtractOptions(opts)
+
+	names := a.List()
+	sort.Strings(names)
+
+	paths := make(map[string]string, len(names)) // name -> destination
+	files := make(map[string]string, len(names)) // case-folded destination -> name
+	dirs := make(map[string]string)              // case-folded parent directory -> name
+	for _, name := range names {
+		rel, err := safePath(name)
+		if err != nil {
+			return &AttErr{Path: a.path, Name: name, Offset: -1, Err: err}
+		}
+		paths[name] = filepath.Join(dir, filepath.FromSlash(rel))
+
+		folded := strings.ToLower(rel)
+		if other, ok := files[folded]; ok {
+			return &AttErr{Path: a.path, Name: name, Offset: -1, Err: collision(other)}
+		}
+		if other, ok := dirs[folded]; ok {
+			return &AttErr{Path: a.path, Name: name, Offset: -1, Err: collision(other)}
+		}
+		files[folded] = name
+		for parent := folded; strings.Contains(parent, "/"); {
+			parent = parent[:strings.LastIndex(parent, "/")]
+			if other, ok := files[parent]; ok {
+				return &AttErr{Path: a.path, Name: name, Offset: -1, Err: collision(other)}
+			}
+			dirs[parent] = name
+		}
+	}
+
+	for _, name := range names {
+		e, _ := a.entry(name)
+		if err := a.extract(e, paths[name], o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract writes a single attachment to the given file path, creating intermediate directories as needed.
+// Returns ErrNotFound if no attachment with that name exists.
+//
+// See ExtractAll for more information.
+func (a *Attachments) Extract(name, path string, opts ...ExtractOption) error {
+	e, ok := a.entry(name)
+	if !ok {
+		return &AttErr{Path: a.path, Name: name, Offset: -1, Err: ErrNotFound}
+	}
+	return a.extract(e, path, applyExtractOptions(opts))
+}
+
+// extract writes the given attachment to dst.
+func (a *Attachments) extract(e *entry, dst string, o *extractOptions) error {
+	if o.skipIdentical {
+		identical, err := a.isIdentical(e, dst)
+		if err != nil {
+			return err
+		}
+		if identical {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	var r io.Reader = a.Reader(e.Name)
+	if e.Digest != nil {
+		r = a.VerifyingReader(e.Name)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+
+	mode := fs.FileMode(defaultExtractMode)
+	if e.Mode != 0 {
+		mode = fs.FileMode(e.Mode).Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if e.ModTime != 0 {
+		modTime := time.Unix(0, e.ModTime)
+		if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	success = true
+	return nil
+}
+
+// isIdentical checks if the file at dst exists and has the same content as the given attachment.
+func (a *Attachments) isIdentical(e *entry, dst string) (bool, error) {
+	file, err := os.Open(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !stat.Mode().IsRegular() || stat.Size() != e.Size {
+		return false, nil
+	}
+
+	if e.Digest != nil { // compare digests, without reading the attachment
+		h, err := a.newDigest(e)
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(h, file); err != nil {
+			return false, err
+		}
+		return bytes.Equal(h.Sum(nil), e.Digest), nil
+	}
+	return equalContent(file, a.Reader(e.Name))
+}
+
+// equalContent compares the content of two readers.
+func equalContent(r1, r2 io.Reader) (bool, error) {
+	buf1 := make([]byte, 32*1024)
+	buf2 := make([]byte, len(buf1))
+	for {
+		n1, err1 := io.ReadFull(r1, buf1)
+		if err1 != nil && err1 != io.EOF && err1 != io.ErrUnexpectedEOF {
+			return false, err1
+		}
+		n2, err2 := io.ReadFull(r2, buf2)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return false, err2
+		}
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 != nil || err2 != nil { // end of data
+			return err1 != nil && err2 != nil, nil
+		}
+	}
+}
+
+// safePath converts an attachment name into a clean, relative, '/'-separated path.
+// Backslashes are treated as separators, as they are on Windows.
+func safePath(name string) (string, error) {
+	p := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(p, "/") {
+		return "", ErrUnsafeName
+	}
+	if len(p) >= 2 && p[1] == ':' && isLetter(p[0]) { // drive letter, like "C:"
+		return "", ErrUnsafeName
+	}
+	if !fs.ValidPath(p) || p == "." {
+		return "", ErrUnsafeName
+	}
+	return p, nil
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// collision returns an ErrNameCollision mentioning the other attachment.
+func collision(other string) error {
+	return fmt.Errorf("%w (conflicts with %q)", ErrNameCollision, other)
+}
@@ -0,0 +1,156 @@
+package ember
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareExtract returns attachments with the given names and contents.
+func prepareExtract(t *testing.T, names ...string) *Attachments {
+	var toc internal.TOC
+	var data [][]byte
+	for _, name := range names {
+		content := "content of " + name
+		toc = append(toc, internal.Attachment{
+			Name:   name,
+			Size:   int64(len(content)),
+			Digest: digest(content),
+		})
+		data = append(data, []byte(content))
+	}
+	path := prepareFile(t, toc, data)
+	t.Cleanup(func() { os.Remove(path) })
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { att.Close() })
+	return att
+}
+
+func assertFile(t *testing.T, path, content string) {
+	data, err := os.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, content, string(data))
+	}
+}
+
+func TestAttachments_ExtractAll(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := prepareFile(t, internal.TOC{
+		{Name: "a.txt", Size: 1},
+		{Name: "dir/sub/b.sh", Size: 2, Mode: 0755, ModTime: modTime.UnixNano()},
+	}, [][]byte{[]byte("a"), []byte("bb")})
+	defer os.Remove(path)
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	dir := t.TempDir()
+	assert.NoError(t, att.ExtractAll(dir))
+
+	assertFile(t, filepath.Join(dir, "a.txt"), "a")
+	assertFile(t, filepath.Join(dir, "dir", "sub", "b.sh"), "bb")
+
+	stat, err := os.Stat(filepath.Join(dir, "dir", "sub", "b.sh"))
+	assert.NoError(t, err)
+	assert.True(t, modTime.Equal(stat.ModTime()))
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0755), stat.Mode().Perm())
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "dir", "sub"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestAttachments_ExtractAll_unsafeNames(t *testing.T) {
+	for _, name := range []string{"../escape", "dir/../../escape", "/absolute", `\absolute`, `..\escape`, "C:/drive", "c:drive", ".", ""} {
+		t.Run(name, func(t *testing.T) {
+			att := prepareExtract(t, "valid", name)
+
+			dir := t.TempDir()
+			err := att.ExtractAll(dir)
+			assert.ErrorIs(t, err, ErrUnsafeName)
+
+			// nothing was written
+			entries, err := os.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestAttachments_ExtractAll_collisions(t *testing.T) {
+	for _, names := range [][]string{
+		{"File.txt", "file.txt"},
+		{"dir", "dir/file"},
+		{"DIR/file", "dir"},
+		{"dir/File", "DIR/file"},
+	} {
+		att := prepareExtract(t, names...)
+		err := att.ExtractAll(t.TempDir())
+		assert.ErrorIs(t, err, ErrNameCollision, "names %v", names)
+	}
+
+	att := prepareExtract(t, "dir/a", "DIR/b")
+	assert.NoError(t, att.ExtractAll(t.TempDir()))
+}
+
+func TestAttachments_ExtractAll_skipIdentical(t *testing.T) {
+	att := prepareExtract(t, "same", "different")
+	dir := t.TempDir()
+	same := filepath.Join(dir, "same")
+	different := filepath.Join(dir, "different")
+
+	assert.NoError(t, os.WriteFile(same, []byte("content of same"), 0600))
+	assert.NoError(t, os.WriteFile(different, []byte("content of ?????????"), 0600))
+	oldTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(same, oldTime, oldTime))
+
+	assert.NoError(t, att.ExtractAll(dir, SkipIdentical()))
+	assertFile(t, same, "content of same")
+	assertFile(t, different, "content of different")
+
+	stat, err := os.Stat(same)
+	assert.NoError(t, err)
+	assert.True(t, oldTime.Equal(stat.ModTime()), "identical file was replaced")
+
+	// without the option, files are always replaced
+	assert.NoError(t, att.ExtractAll(dir))
+	stat, err = os.Stat(same)
+	assert.NoError(t, err)
+	assert.False(t, oldTime.Equal(stat.ModTime()), "identical file was not replaced")
+}
+
+func TestAttachments_Extract(t *testing.T) {
+	att := prepareExtract(t, "../unsafe/name")
+
+	path := filepath.Join(t.TempDir(), "sub", "file")
+	assert.NoError(t, att.Extract("../unsafe/name", path))
+	assertFile(t, path, "content of ../unsafe/name")
+
+	err := att.Extract("unknown", path)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestAttachments_Extract_checksumMismatch(t *testing.T) {
+	path := prepareFile(t, internal.TOC{
+		{Name: "corrupt", Size: 7, Digest: digest("invalid")},
+	}, [][]byte{[]byte("corrupt")})
+	defer os.Remove(path)
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	dst := filepath.Join(t.TempDir(), "corrupt")
+	assert.ErrorIs(t, att.Extract("corrupt", dst), ErrChecksumMismatch)
+	_, err = os.Stat(dst)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
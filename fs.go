@@ -0,0 +1,357 @@
+package ember
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ensure that Attachments can be used as a file system.
+var (
+	_ fs.FS         = (*Attachments)(nil)
+	_ fs.ReadDirFS  = (*Attachments)(nil)
+	_ fs.ReadFileFS = (*Attachments)(nil)
+	_ fs.StatFS     = (*Attachments)(nil)
+	_ fs.GlobFS     = (*Attachments)(nil)
+)
+
+// Open opens the named attachment or synthesized directory.
+// Attachment names are treated as '/'-separated paths, and directories are derived from them.
+// For example, the attachment "config/app.json" results in a directory "config" containing the file "app.json".
+//
+// Only attachment names that are valid according to fs.ValidPath are accessible via the fs.FS interface.
+// If an attachment name is also the parent directory of other attachments, the attachment takes precedence.
+//
+// The returned file is an independent handle that must be closed after use.
+// It also implements io.Seeker and io.ReaderAt.
+// Open handles stay valid after Close was called on the attachments; the executable is closed
+// once the last handle is closed. Opening new handles after Close fails with ErrClosed.
+//
+// Open implements fs.FS.
+func (a *Attachments) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	idx := a.fsIndex()
+	if idx.files[name] {
+		if err := a.acquire(); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		e, _ := a.entry(name)
+		r, err := a.reader(e, a.exe)
+		if err != nil {
+			_ = a.release()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &file{
+			Reader: r,
+			info:   a.fileInfo(name),
+			att:    a,
+		}, nil
+	}
+	if entries, ok := idx.dirs[name]; ok {
+		return &dir{
+			info:    dirInfo(name),
+			entries: entries,
+		}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir reads the named directory and returns a list of directory entries sorted by filename.
+//
+// ReadDir implements fs.ReadDirFS.
+func (a *Attachments) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	idx := a.fsIndex()
+	entries, ok := idx.dirs[name]
+	if !ok {
+		if idx.files[name] {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	list := make([]fs.DirEntry, len(entries))
+	copy(list, entries)
+	return list, nil
+}
+
+// ReadFile reads the named attachment and returns its contents.
+//
+// ReadFile implements fs.ReadFileFS.
+func (a *Attachments) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	idx := a.fsIndex()
+	if !idx.files[name] {
+		if _, ok := idx.dirs[name]; ok {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+		}
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	data := make([]byte, a.Size(name))
+	if _, err := io.ReadFull(a.Reader(name), data); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// Stat returns a FileInfo describing the named attachment or directory.
+//
+// Stat implements fs.StatFS.
+func (a *Attachments) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	idx := a.fsIndex()
+	if idx.files[name] {
+		return a.fileInfo(name), nil
+	}
+	if _, ok := idx.dirs[name]; ok {
+		return dirInfo(name), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Glob returns the names of all attachments and directories matching pattern.
+// The syntax of patterns is the same as in path.Match.
+//
+// Glob implements fs.GlobFS.
+func (a *Attachments) Glob(pattern string) ([]string, error) {
+	// check pattern syntax
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	idx := a.fsIndex()
+
+	var matches []string
+	for name := range idx.files {
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	for name := range idx.dirs {
+		if name == "." {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// fileInfo returns the FileInfo of an existing attachment.
+// Attachments embedded without file mode are reported as read-only.
+func (a *Attachments) fileInfo(name string) *fileInfo {
+	e, _ := a.entry(name)
+	info := &fileInfo{
+		name: path.Base(name),
+		size: e.Size,
+		mode: 0444,
+	}
+	if e.Mode != 0 {
+		info.mode = fs.FileMode(e.Mode).Perm()
+	}
+	if e.ModTime != 0 {
+		info.modTime = time.Unix(0, e.ModTime)
+	}
+	return info
+}
+
+// dirInfo returns the FileInfo of a synthesized directory.
+func dirInfo(name string) *fileInfo {
+	return &fileInfo{
+		name: path.Base(name),
+		mode: fs.ModeDir | 0555,
+	}
+}
+
+// fsIndex returns the directory tree of all attachments.
+// The tree is built on first use.
+func (a *Attachments) fsIndex() *fsIndex {
+	a.fsOnce.Do(func() {
+		a.fsIdx = buildFSIndex(a)
+	})
+	return a.fsIdx
+}
+
+// fsIndex is a directory tree synthesized from the '/'-separated attachment names.
+type fsIndex struct {
+	files map[string]bool          // attachments accessible via fs.FS
+	dirs  map[string][]fs.DirEntry // directory path ("." for root) -> entries sorted by filename
+}
+
+func buildFSIndex(a *Attachments) *fsIndex {
+	idx := &fsIndex{
+		files: make(map[string]bool),
+		dirs:  make(map[string][]fs.DirEntry),
+	}
+
+	names := a.List()
+	for _, name := range names {
+		if name != "." && fs.ValidPath(name) {
+			idx.files[name] = true
+		}
+	}
+
+	children := map[string]map[string]fs.DirEntry{
+		".": {},
+	}
+nextFile:
+	for name := range idx.files {
+		// attachments located 'within' other attachments are not accessible
+		for p := path.Dir(name); p != "."; p = path.Dir(p) {
+			if idx.files[p] {
+				delete(idx.files, name)
+				continue nextFile
+			}
+		}
+
+		entry := fs.FileInfoToDirEntry(a.fileInfo(name))
+		for p := name; p != "."; p = path.Dir(p) {
+			parent := path.Dir(p)
+			if children[parent] == nil {
+				children[parent] = make(map[string]fs.DirEntry)
+			}
+			children[parent][path.Base(p)] = entry
+			entry = fs.FileInfoToDirEntry(dirInfo(parent))
+		}
+	}
+
+	for dirName, entries := range children {
+		list := make([]fs.DirEntry, 0, len(entries))
+		for _, e := range entries {
+			list = append(list, e)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name() < list[j].Name()
+		})
+		idx.dirs[dirName] = list
+	}
+	return idx
+}
+
+// errNotDir and errIsDir are reported when the type of a path does not match the requested operation.
+var (
+	errNotDir = errors.New("not a directory")
+	errIsDir  = errors.New("is a directory")
+)
+
+// fileInfo describes an attachment or a synthesized directory.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+// file is an opened attachment.
+// It keeps the executable open until it is closed.
+type file struct {
+	Reader
+	info *fileInfo
+	att  *Attachments
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isClosed() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.isClosed() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.Reader.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isClosed() {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.Reader.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return f.att.release()
+}
+
+func (f *file) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// dir is an opened, synthesized directory.
+type dir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+	closed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errIsDir}
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.info.Name(), Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.info.Name(), Err: fs.ErrClosed}
+	}
+	remaining := len(d.entries) - d.offset
+	if n > 0 && remaining == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > remaining {
+		n = remaining
+	}
+	list := make([]fs.DirEntry, n)
+	copy(list, d.entries[d.offset:])
+	d.offset += n
+	return list, nil
+}
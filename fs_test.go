@@ -0,0 +1,133 @@
+package ember
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareFS(t *testing.T, files map[string]string) *Attachments {
+	var toc internal.TOC
+	var data [][]byte
+	for name, content := range files {
+		toc = append(toc, internal.Attachment{
+			Name: name,
+			Size: int64(len(content)),
+		})
+		data = append(data, []byte(content))
+	}
+
+	path := prepareFile(t, toc, data)
+	t.Cleanup(func() { _ = os.Remove(path) })
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = att.Close() })
+	return att
+}
+
+func TestAttachments_FS(t *testing.T) {
+	att := prepareFS(t, map[string]string{
+		"root.txt":               "root",
+		"config/app.json":        "{}",
+		"config/db/conn.json":    `{"host":"localhost"}`,
+		"templates/mail.tmpl":    "Hello {{.Name}}",
+		"templates/partial.tmpl": "",
+	})
+
+	err := fstest.TestFS(att,
+		"root.txt",
+		"config/app.json",
+		"config/db/conn.json",
+		"templates/mail.tmpl",
+		"templates/partial.tmpl",
+	)
+	assert.NoError(t, err)
+
+	t.Run("ReadDir()", func(t *testing.T) {
+		entries, err := att.ReadDir(".")
+		assert.NoError(t, err)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		assert.Equal(t, []string{"config", "root.txt", "templates"}, names)
+		assert.True(t, entries[0].IsDir())
+		assert.False(t, entries[1].IsDir())
+
+		_, err = att.ReadDir("root.txt")
+		assert.Error(t, err)
+
+		_, err = att.ReadDir("unknown")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("ReadFile()", func(t *testing.T) {
+		data, err := att.ReadFile("config/db/conn.json")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"host":"localhost"}`, string(data))
+
+		_, err = att.ReadFile("config")
+		assert.Error(t, err)
+
+		_, err = att.ReadFile("/root.txt")
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+	})
+
+	t.Run("Stat()", func(t *testing.T) {
+		info, err := att.Stat("config/db")
+		assert.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.Equal(t, "db", info.Name())
+
+		info, err = att.Stat("templates/mail.tmpl")
+		assert.NoError(t, err)
+		assert.False(t, info.IsDir())
+		assert.Equal(t, int64(15), info.Size())
+	})
+
+	t.Run("Glob()", func(t *testing.T) {
+		matches, err := att.Glob("templates/*.tmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"templates/mail.tmpl", "templates/partial.tmpl"}, matches)
+
+		matches, err = fs.Glob(att, "*/*")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"config/app.json", "config/db", "templates/mail.tmpl", "templates/partial.tmpl"}, matches)
+
+		_, err = att.Glob("[")
+		assert.Error(t, err)
+	})
+}
+
+func TestAttachments_FS_invalidNames(t *testing.T) {
+	att := prepareFS(t, map[string]string{
+		"file":       "file content",
+		"file/inner": "not accessible",
+		"/absolute":  "not accessible",
+		"../parent":  "not accessible",
+	})
+
+	err := fstest.TestFS(att, "file")
+	assert.NoError(t, err)
+
+	_, err = att.Open("file/inner")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	entries, err := att.ReadDir(".")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestAttachments_FS_empty(t *testing.T) {
+	att, err := Open()
+	assert.NoError(t, err)
+	defer att.Close()
+
+	err = fstest.TestFS(att)
+	assert.NoError(t, err)
+}
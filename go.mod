@@ -1,5 +1,12 @@
 module github.com/maja42/ember
 
-go 1.15
+go 1.18
 
 require github.com/stretchr/testify v1.9.0
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/compress v1.15.15
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
+)
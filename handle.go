@@ -0,0 +1,56 @@
+package ember
+
+import (
+	"io/fs"
+
+	"github.com/maja42/ember/internal"
+)
+
+// ErrClosed is returned when accessing attachments after they were closed.
+// It matches fs.ErrClosed.
+var ErrClosed error = internal.NewCategorizedErr("attachments already closed", fs.ErrClosed)
+
+// acquire registers a new handle, which keeps the executable open.
+// Fails if the attachments are already closed.
+func (a *Attachments) acquire() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	a.handles++
+	return nil
+}
+
+// release unregisters a handle.
+// If the attachments were closed in the meantime, the executable is closed together with the last handle.
+func (a *Attachments) release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.handles--
+	if a.closed && a.handles == 0 {
+		return a.closeExe()
+	}
+	return nil
+}
+
+// isClosed reports whether Close was called.
+func (a *Attachments) isClosed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
+
+// guardedReaderAt reads from the executable, but fails once the attachments are closed.
+type guardedReaderAt struct {
+	a *Attachments
+}
+
+func (g guardedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if g.a.isClosed() {
+		return 0, ErrClosed
+	}
+	return g.a.exe.ReadAt(p, off)
+}
@@ -0,0 +1,73 @@
+package ember
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachments_handles(t *testing.T) {
+	var testTOC = internal.TOC{
+		{Name: "att1", Size: 5},
+		{Name: "att2", Size: 5},
+	}
+	path := prepareFile(t, testTOC, [][]byte{[]byte("first"), []byte("other")})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+
+	handle, err := att.Open("att1")
+	assert.NoError(t, err)
+	reader := att.Reader("att2")
+
+	// close attachments while the handle is still open
+	assert.NoError(t, att.Close())
+	assert.Equal(t, ErrClosed, att.Close())
+
+	_, err = att.Open("att2")
+	assert.ErrorIs(t, err, ErrClosed)
+
+	_, err = io.ReadAll(reader)
+	assert.Equal(t, ErrClosed, err)
+	_, err = io.ReadAll(att.Reader("att2"))
+	assert.Equal(t, ErrClosed, err)
+
+	// the handle is still usable
+	data, err := io.ReadAll(handle)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+
+	// closing the last handle closes the executable
+	assert.NoError(t, handle.Close())
+	_, err = att.exe.(*os.File).Stat()
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	_, err = handle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, fs.ErrClosed)
+	assert.ErrorIs(t, handle.Close(), fs.ErrClosed)
+}
+
+func TestAttachments_handlesClosedFirst(t *testing.T) {
+	var testTOC = internal.TOC{
+		{Name: "att", Size: 5},
+	}
+	path := prepareFile(t, testTOC, [][]byte{[]byte("first")})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+
+	handle, err := att.Open("att")
+	assert.NoError(t, err)
+	assert.NoError(t, handle.Close())
+
+	// no open handles: the executable is closed immediately
+	assert.NoError(t, att.Close())
+	_, err = att.exe.(*os.File).Stat()
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -60,6 +60,7 @@ func SeekPattern(in io.ReadSeeker, pattern []byte) int64 {
 
 	var offset int64
 	r := bufio.NewReader(in)
+	fallback := prefixTable(pattern)
 
 	nIdx := 0 // #bytes we already found
 	for nIdx < len(pattern) {
@@ -67,10 +68,13 @@ func SeekPattern(in io.ReadSeeker, pattern []byte) int64 {
 		if err != nil { // not found
 			return -1
 		}
+		// On mismatch, continue with the longest partial match that is still possible.
+		// Otherwise, patterns following a partial match would be missed.
+		for nIdx > 0 && pattern[nIdx] != b {
+			nIdx = fallback[nIdx-1]
+		}
 		if pattern[nIdx] == b {
 			nIdx++
-		} else {
-			nIdx = 0
 		}
 		offset++
 	}
@@ -79,3 +83,20 @@ func SeekPattern(in io.ReadSeeker, pattern []byte) int64 {
 	_, _ = in.Seek(rPos+offset, io.SeekStart)
 	return offset
 }
+
+// prefixTable returns the Knuth-Morris-Pratt prefix table of the pattern.
+// table[i] is the length of the longest proper prefix of pattern[:i+1] that is also a suffix of it.
+func prefixTable(pattern []byte) []int {
+	table := make([]int, len(pattern))
+	k := 0
+	for i := 1; i < len(pattern); i++ {
+		for k > 0 && pattern[i] != pattern[k] {
+			k = table[k-1]
+		}
+		if pattern[i] == pattern[k] {
+			k++
+		}
+		table[i] = k
+	}
+	return table
+}
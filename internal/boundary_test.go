@@ -108,3 +108,36 @@ func TestSeekBoundary_noBoundary(t *testing.T) {
 	offset := SeekBoundary(r)
 	assert.Equal(t, int64(-1), offset)
 }
+
+func TestSeekBoundary_partialMatch(t *testing.T) {
+	// The data starts with parts of the boundary pattern, followed by the complete boundary.
+	for i := 1; i < len(boundary); i++ {
+		buf := bytes.NewBuffer(nil)
+		buf.Write(boundary[:i])
+		buf.Write(boundary)
+		buf.WriteString("text")
+
+		data := buf.Bytes()
+		expected := bytes.Index(data, boundary) + len(boundary)
+
+		r := bytes.NewReader(data)
+		offset := SeekBoundary(r)
+		assert.Equal(t, int64(expected), offset, "prefix length %d", i)
+
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data[expected:], content)
+	}
+}
+
+func TestSeekPattern_partialMatch(t *testing.T) {
+	// After the mismatch at "aa|a", the search must continue with the partial match "a",
+	// instead of starting over behind the mismatching byte.
+	r := bytes.NewReader([]byte("aaab-rest"))
+	offset := SeekPattern(r, []byte("aab"))
+	assert.Equal(t, int64(4), offset)
+
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "-rest", string(content))
+}
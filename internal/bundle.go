@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Errors reported by ParseLayers, wrapped in a *ParseError.
+var (
+	ErrIncompleteTOC  = errors.New("incomplete TOC")
+	ErrInvalidFooter  = errors.New("invalid footer")
+	ErrInvalidOffsets = errors.New("invalid offsets")
+	ErrTruncated      = errors.New("truncated attachment data")
+)
+
+// ParseError reports invalid attachment data within an executable.
+type ParseError struct {
+	Name   string // Affected attachment; empty if the problem is not specific to one attachment
+	Offset int64  // Position within the executable where the problem was detected
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("attachment %q: offset %d: %v", e.Name, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Layer is a single TOC and the attachment data following it.
+type Layer struct {
+	TOC        []byte // serialized TOC
+	Index      Index
+	TOCOffset  int64      // offset of the TOC
+	DataOffset int64      // offset of the first attachment
+	Signature  *Signature // nil if unsigned
+	End        int64      // offset after the layer's trailing boundary, signature and footer
+}
+
+// Entry returns the i-th attachment of the layer, and the absolute offset of its data.
+func (l *Layer) Entry(i int) (Attachment, int64) {
+	a, offset := l.Index.Entry(i)
+	return a, l.DataOffset + offset
+}
+
+// Lookup returns the attachment with the given name, and the absolute offset of its data.
+// The attachment might be a tombstone.
+func (l *Layer) Lookup(name string) (Attachment, int64, bool) {
+	i, ok := l.Index.Lookup(name)
+	if !ok {
+		return Attachment{}, 0, false
+	}
+	a, offset := l.Entry(i)
+	return a, offset, true
+}
+
+// Start returns the offset of the boundary before the layer's TOC.
+func (l *Layer) Start() int64 {
+	return l.TOCOffset - int64(BoundarySize)
+}
+
+// ParseLayers parses all layers of attachments within the executable, in the order of their location.
+// Returns nil if the executable contains no attachments.
+//
+// Problems with the attachment data are reported as *ParseError.
+func ParseLayers(exe io.ReaderAt, exeSize int64) ([]*Layer, error) {
+	// determine TOC location of the first layer
+	tocOffset, tocSize, hasFooter, err := locateTOC(exe, exeSize)
+	if err != nil {
+		return nil, err
+	}
+	if tocOffset < 0 { // No attachments found
+		return nil, nil
+	}
+
+	var layers []*Layer
+	for {
+		l, err := parseLayer(exe, exeSize, tocOffset, tocSize)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+
+		// the next layer directly follows the previous one
+		next, err := isBoundaryAt(exe, exeSize, l.End)
+		if err != nil {
+			return nil, err
+		}
+		if !next {
+			if hasFooter && l.End != exeSize {
+				// the footer must follow the trailing boundary (and signature)
+				return nil, &ParseError{Offset: l.End, Err: ErrInvalidOffsets}
+			}
+			return layers, nil
+		}
+		tocOffset = l.End + int64(BoundarySize)
+		if tocSize, err = scanTOC(exe, exeSize, tocOffset); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseLayer parses the layer with the given TOC location.
+func parseLayer(exe io.ReaderAt, exeSize int64, tocOffset, tocSize int64) (*Layer, error) {
+	l := &Layer{
+		TOC:       make([]byte, tocSize),
+		TOCOffset: tocOffset,
+	}
+
+	// read TOC
+	if _, err := exe.ReadAt(l.TOC, tocOffset); err != nil {
+		return nil, err
+	}
+	index, err := ParseTOC(l.TOC)
+	if err != nil {
+		var entryErr *EntryError
+		if errors.As(err, &entryErr) {
+			return nil, &ParseError{Name: entryErr.Name, Offset: tocOffset, Err: entryErr.Err}
+		}
+		return nil, &ParseError{Offset: tocOffset, Err: err}
+	}
+	l.Index = index
+	l.DataOffset = tocOffset + tocSize + int64(BoundarySize)
+	offset := l.DataOffset + index.DataSize()
+
+	// find trailing boundary
+	var trailer = make([]byte, BoundarySize)
+	if _, err := exe.ReadAt(trailer, offset); err != nil {
+		if err == io.EOF { // offsets point outside executable (missing data?)
+			return nil, &ParseError{Offset: offset, Err: ErrTruncated}
+		}
+		return nil, err
+	}
+	if !IsBoundary(trailer) {
+		return nil, &ParseError{Offset: offset, Err: ErrInvalidOffsets}
+	}
+	l.End = offset + int64(BoundarySize)
+
+	// read signature
+	if exeSize-l.End >= SignatureSize {
+		var data = make([]byte, SignatureSize)
+		if _, err := exe.ReadAt(data, l.End); err != nil {
+			return nil, err
+		}
+		if sig, ok := ParseSignature(data); ok {
+			l.Signature = &sig
+			l.End += SignatureSize
+		}
+	}
+
+	// read footer
+	footer, ok, err := readFooter(exe, exeSize, l.End+FooterSize)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if footer.TOCOffset != tocOffset || footer.TOCSize != tocSize {
+			return nil, &ParseError{Offset: l.End, Err: ErrInvalidFooter}
+		}
+		l.End += FooterSize
+	}
+	return l, nil
+}
+
+// locateTOC returns the offset and size of the TOC of the first layer within the executable.
+// The TOC is located via the footers at the end of each layer.
+// If there is no footer (eg. because the executable was augmented by an older version of ember,
+// or because additional data was appended afterwards), the executable is scanned for the boundary instead.
+// Returns a negative offset if the executable contains no attachments.
+func locateTOC(exe io.ReaderAt, exeSize int64) (tocOffset, tocSize int64, hasFooter bool, err error) {
+	footer, ok, err := readFooter(exe, exeSize, exeSize)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if !ok { // No footer; scan the whole executable
+		tocOffset = SeekBoundary(io.NewSectionReader(exe, 0, exeSize))
+		if tocOffset < 0 { // No attachments found
+			return -1, 0, false, nil
+		}
+		tocSize, err = scanTOC(exe, exeSize, tocOffset)
+		return tocOffset, tocSize, false, err
+	}
+
+	if err := checkFooter(exe, exeSize, footer); err != nil {
+		return 0, 0, false, err
+	}
+	// follow the footers of previous layers, each located directly before the next layer
+	layerStart := footer.TOCOffset - int64(BoundarySize)
+	for {
+		prev, ok, err := readFooter(exe, exeSize, layerStart)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if !ok {
+			break
+		}
+		if err := checkFooter(exe, layerStart, prev); err != nil {
+			return 0, 0, false, err
+		}
+		footer = prev
+		layerStart = footer.TOCOffset - int64(BoundarySize)
+	}
+
+	// the first layer might have been created by an older version of ember, without footer
+	older, err := isBoundaryAt(exe, exeSize, layerStart-int64(BoundarySize))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if older {
+		tocOffset = SeekBoundary(io.NewSectionReader(exe, 0, layerStart))
+		tocSize, err = scanTOC(exe, exeSize, tocOffset)
+		return tocOffset, tocSize, true, err
+	}
+	return footer.TOCOffset, footer.TOCSize, true, nil
+}
+
+// scanTOC returns the size of the TOC at the given offset.
+// The size of binary TOCs is stored in their header; JSON TOCs are scanned for the boundary at their end.
+func scanTOC(exe io.ReaderAt, exeSize int64, tocOffset int64) (int64, error) {
+	if exeSize-tocOffset >= TOCHeaderSize {
+		var header = make([]byte, TOCHeaderSize)
+		if _, err := exe.ReadAt(header, tocOffset); err != nil {
+			return 0, err
+		}
+		if size, ok := BinaryTOCSize(header); ok {
+			if size > exeSize-tocOffset {
+				return 0, &ParseError{Offset: tocOffset, Err: ErrIncompleteTOC}
+			}
+			return size, nil
+		}
+	}
+
+	nextBoundary := SeekBoundary(io.NewSectionReader(exe, tocOffset, exeSize-tocOffset))
+	if nextBoundary < 0 {
+		// first boundary was found, but the next one (indicating the end of TOC data) is missing.
+		return 0, &ParseError{Offset: tocOffset, Err: ErrIncompleteTOC}
+	}
+	return nextBoundary - int64(BoundarySize), nil
+}
+
+// readFooter reads the footer that ends at the given offset.
+// Returns false if there is no footer, and ErrFormatTooNew if its version is unknown.
+func readFooter(exe io.ReaderAt, exeSize int64, end int64) (Footer, bool, error) {
+	if end < FooterSize || end > exeSize {
+		return Footer{}, false, nil
+	}
+	var data = make([]byte, FooterSize)
+	if _, err := exe.ReadAt(data, end-FooterSize); err != nil {
+		return Footer{}, false, err
+	}
+	footer, ok := ParseFooter(data)
+	if !ok {
+		return Footer{}, false, nil
+	}
+	if footer.Version > FooterVersion {
+		err := fmt.Errorf("%w (footer version %d)", ErrFormatTooNew, footer.Version)
+		return Footer{}, false, &ParseError{Offset: end - FooterSize, Err: err}
+	}
+	return footer, true, nil
+}
+
+// isBoundaryAt checks if a boundary is located at the given offset.
+func isBoundaryAt(exe io.ReaderAt, exeSize int64, offset int64) (bool, error) {
+	if offset < 0 || offset+int64(BoundarySize) > exeSize {
+		return false, nil
+	}
+	var data = make([]byte, BoundarySize)
+	if _, err := exe.ReadAt(data, offset); err != nil {
+		return false, err
+	}
+	return IsBoundary(data), nil
+}
+
+// checkFooter ensures that the TOC referenced by the footer is guarded by boundaries on both sides.
+// end is the offset directly after the footer.
+func checkFooter(exe io.ReaderAt, end int64, footer Footer) error {
+	boundarySize := int64(BoundarySize)
+	start := footer.TOCOffset - boundarySize
+	tocEnd := footer.TOCOffset + footer.TOCSize + boundarySize
+	invalid := &ParseError{Offset: end - FooterSize, Err: ErrInvalidFooter}
+	if footer.TOCSize < 0 || start < 0 || tocEnd > end-FooterSize {
+		return invalid
+	}
+
+	for _, offset := range []int64{start, tocEnd - boundarySize} {
+		ok, err := isBoundaryAt(exe, end, offset)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// ReadSeekerAt adapts an io.ReadSeeker to an io.ReaderAt.
+// All accesses are serialized.
+type ReadSeekerAt struct {
+	mu sync.Mutex
+	RS io.ReadSeeker
+}
+
+func (r *ReadSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.RS.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.RS, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression codecs, as stored in the TOC.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// IsSupportedCompression checks if the given compression codec is known.
+func IsSupportedCompression(codec string) bool {
+	switch codec {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// NewCompressor returns a writer that compresses all data before writing it to w.
+// The writer must be closed to flush all data.
+// The compressed output is deterministic.
+func NewCompressor(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	}
+	return nil, fmt.Errorf("unsupported compression %q", codec)
+}
+
+// NewDecompressor returns a reader that decompresses the data read from r.
+func NewDecompressor(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{dec}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// zstdReadCloser adapts the zstd decoder, whose Close method does not return an error.
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// Supported encryption schemes, as stored in the TOC.
+const (
+	EncryptionNone      = ""
+	EncryptionAES256GCM = "aes-256-gcm"
+)
+
+// KeySize is the size of encryption keys in bytes.
+const KeySize = 32
+
+// SaltSize is the size of the per-attachment salt in bytes.
+const SaltSize = 32
+
+// EncryptionChunkSize is the maximum amount of plaintext per encrypted chunk.
+//
+// Encrypted data is split into chunks, which are sealed individually.
+// The nonce of each chunk contains the chunk index and a flag marking the final chunk,
+// which prevents reordering and truncation.
+// The final chunk always contains less than EncryptionChunkSize bytes of plaintext, and might be empty.
+const EncryptionChunkSize = 64 * 1024
+
+const tagSize = 16
+const encryptedChunkSize = EncryptionChunkSize + tagSize
+
+// ErrDecryption is returned if encrypted data could not be authenticated.
+// It matches ErrCorrupt.
+var ErrDecryption error = &categorizedErr{"decryption failed (wrong key or corrupt data)", ErrCorrupt}
+
+// IsSupportedEncryption checks if the given encryption scheme is known.
+func IsSupportedEncryption(scheme string) bool {
+	return scheme == EncryptionNone || scheme == EncryptionAES256GCM
+}
+
+// ParseKey parses an encryption key.
+// The key can be hex- or base64-encoded, or consist of raw bytes.
+// Surrounding whitespace of encoded keys is ignored.
+//
+// Raw keys are only accepted if they are not printable text,
+// so that text with a wrong length (like a truncated or 16-byte hex key) is not mistaken for key material.
+func ParseKey(data []byte) ([]byte, error) {
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if len(data) == KeySize && !isText(data) {
+		return data, nil
+	}
+	return nil, fmt.Errorf("invalid key (expected %d bytes, hex- or base64-encoded)", KeySize)
+}
+
+// isText checks if the data only consists of printable ASCII characters and whitespace.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7E) && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// DeriveSalt returns the salt of an attachment, derived from the key, the attachment's name, the compression
+// and the digest of the compressed data (the bytes that are actually encrypted).
+// Embedding the same content therefore produces the same ciphertext, while different plaintexts never share a salt.
+func DeriveSalt(key []byte, name, compression string, compressedDigest []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("maja42/ember salt\x00"))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(compression))
+	mac.Write([]byte{0})
+	mac.Write(compressedDigest)
+	return mac.Sum(nil)[:SaltSize]
+}
+
+// deriveKey derives a per-attachment subkey from the master key.
+func deriveKey(key, salt []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("maja42/ember " + purpose + "\x00"))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// NewDigest returns the hash used for the digest of an attachment.
+// Encrypted attachments use a keyed digest (HMAC-SHA256) to avoid leaking information about the plaintext.
+func NewDigest(scheme string, key, salt []byte) hash.Hash {
+	if scheme == EncryptionNone {
+		return sha256.New()
+	}
+	return hmac.New(sha256.New, deriveKey(key, salt, "digest"))
+}
+
+// EncryptedSize returns the size of encrypted data with the given plaintext size.
+func EncryptedSize(plainSize int64) int64 {
+	chunks := plainSize/EncryptionChunkSize + 1
+	return plainSize + chunks*tagSize
+}
+
+// decryptedSize returns the plaintext size of the encrypted data with the given size.
+func decryptedSize(encSize int64) (int64, bool) {
+	lastChunk := encSize % encryptedChunkSize
+	if lastChunk < tagSize {
+		return 0, false
+	}
+	fullChunks := encSize / encryptedChunkSize
+	return fullChunks*EncryptionChunkSize + lastChunk - tagSize, true
+}
+
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d (expected %d)", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(deriveKey(key, salt, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for the given chunk.
+func chunkNonce(index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[7:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// NewEncryptor returns a writer that encrypts all data before writing it to w.
+// The writer must be closed to write the final chunk.
+func NewEncryptor(w io.Writer, key, salt []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, EncryptionChunkSize),
+	}, nil
+}
+
+type encryptor struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte // pending plaintext
+	index uint32
+}
+
+func (e *encryptor) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		// The final chunk must be smaller than the chunk size.
+		// Full chunks are therefore only written once more data arrives.
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.writeChunk(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptor) Close() error {
+	if len(e.buf) == cap(e.buf) {
+		if err := e.writeChunk(false); err != nil {
+			return err
+		}
+	}
+	return e.writeChunk(true)
+}
+
+func (e *encryptor) writeChunk(last bool) error {
+	if e.index == ^uint32(0) {
+		return errors.New("too much data")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.index, last), e.buf, nil)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Decryptor provides random access to encrypted data.
+type Decryptor struct {
+	r       io.ReaderAt
+	encSize int64
+	size    int64
+	aead    cipher.AEAD
+
+	mu         sync.Mutex
+	chunk      []byte // most recently decrypted chunk
+	chunkIndex int64  // index of the decrypted chunk; -1 if none
+}
+
+// NewDecryptor returns a ReaderAt for the plaintext of the given encrypted data.
+func NewDecryptor(r io.ReaderAt, encSize int64, key, salt []byte) (*Decryptor, error) {
+	size, ok := decryptedSize(encSize)
+	if !ok {
+		return nil, ErrDecryption
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Decryptor{
+		r:          r,
+		encSize:    encSize,
+		size:       size,
+		aead:       aead,
+		chunkIndex: -1,
+	}, nil
+}
+
+// Size returns the plaintext size.
+func (d *Decryptor) Size() int64 {
+	return d.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (d *Decryptor) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		index := off / EncryptionChunkSize
+		if err := d.decryptChunk(index); err != nil {
+			return n, err
+		}
+		c := copy(p[n:], d.chunk[off-index*EncryptionChunkSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// decryptChunk decrypts and caches the chunk with the given index.
+func (d *Decryptor) decryptChunk(index int64) error {
+	if d.chunkIndex == index {
+		return nil
+	}
+	start := index * encryptedChunkSize
+	size := int64(encryptedChunkSize)
+	if start+size > d.encSize {
+		size = d.encSize - start
+	}
+	last := start+size == d.encSize
+
+	sealed := make([]byte, size)
+	if _, err := d.r.ReadAt(sealed, start); err != nil {
+		return err
+	}
+	chunk, err := d.aead.Open(sealed[:0], chunkNonce(uint32(index), last), sealed, nil)
+	if err != nil {
+		d.chunkIndex = -1
+		return ErrDecryption
+	}
+	d.chunk = chunk
+	d.chunkIndex = index
+	return nil
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encrypt(t *testing.T, key, salt, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptor(&buf, key, salt)
+	assert.NoError(t, err)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestEncryption(t *testing.T) {
+	key := make([]byte, KeySize)
+	salt := make([]byte, SaltSize)
+	_, _ = rand.Read(key)
+	_, _ = rand.Read(salt)
+
+	for _, size := range []int{0, 1, EncryptionChunkSize - 1, EncryptionChunkSize, EncryptionChunkSize + 1, 3*EncryptionChunkSize + 42} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+
+		encrypted := encrypt(t, key, salt, data)
+		assert.Equal(t, EncryptedSize(int64(size)), int64(len(encrypted)), "size %d", size)
+
+		dec, err := NewDecryptor(bytes.NewReader(encrypted), int64(len(encrypted)), key, salt)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), dec.Size())
+
+		decrypted, err := io.ReadAll(io.NewSectionReader(dec, 0, dec.Size()))
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, decrypted), "size %d", size)
+
+		if size > 10 {
+			buf := make([]byte, 10)
+			n, err := dec.ReadAt(buf, int64(size-5))
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, data[size-5:], buf[:n])
+		}
+	}
+}
+
+func TestDecryptor_wrongKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	salt := make([]byte, SaltSize)
+	encrypted := encrypt(t, key, salt, []byte("secret"))
+
+	otherKey := make([]byte, KeySize)
+	otherKey[0] = 1
+	dec, err := NewDecryptor(bytes.NewReader(encrypted), int64(len(encrypted)), otherKey, salt)
+	assert.NoError(t, err)
+	_, err = dec.ReadAt(make([]byte, 6), 0)
+	assert.Equal(t, ErrDecryption, err)
+
+	otherSalt := make([]byte, SaltSize)
+	otherSalt[0] = 1
+	dec, err = NewDecryptor(bytes.NewReader(encrypted), int64(len(encrypted)), key, otherSalt)
+	assert.NoError(t, err)
+	_, err = dec.ReadAt(make([]byte, 6), 0)
+	assert.Equal(t, ErrDecryption, err)
+}
+
+func TestDecryptor_truncated(t *testing.T) {
+	key := make([]byte, KeySize)
+	salt := make([]byte, SaltSize)
+	data := make([]byte, 2*EncryptionChunkSize+100)
+	encrypted := encrypt(t, key, salt, data)
+
+	// drop the final chunk
+	truncated := encrypted[:2*encryptedChunkSize]
+	_, err := NewDecryptor(bytes.NewReader(truncated), int64(len(truncated)), key, salt)
+	assert.Equal(t, ErrDecryption, err)
+
+	// drop the final chunk and parts of the previous one; the new final chunk is not marked as such
+	truncated = encrypted[:2*encryptedChunkSize-100]
+	dec, err := NewDecryptor(bytes.NewReader(truncated), int64(len(truncated)), key, salt)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(io.NewSectionReader(dec, 0, dec.Size()))
+	assert.Equal(t, ErrDecryption, err)
+
+	_, err = NewDecryptor(bytes.NewReader(encrypted), 5, key, salt)
+	assert.Equal(t, ErrDecryption, err)
+}
+
+func TestParseKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	_, _ = rand.Read(key)
+	key[0] = 0xFF // ensure the raw key is not printable
+
+	parsed, err := ParseKey(key)
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	parsed, err = ParseKey([]byte(hex.EncodeToString(key) + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	parsed, err = ParseKey([]byte(base64.StdEncoding.EncodeToString(key)))
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = ParseKey([]byte("too short"))
+	assert.Error(t, err)
+
+	// text of the raw key size is not mistaken for a raw key
+	_, err = ParseKey([]byte(hex.EncodeToString(key[:16])))
+	assert.Error(t, err, "16-byte hex key")
+	_, err = ParseKey([]byte("this is not a key, but 32 chars\n"))
+	assert.Error(t, err)
+}
+
+func TestDeriveSalt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	digest := bytes.Repeat([]byte{2}, 32)
+
+	salt := DeriveSalt(key, "att", CompressionNone, digest)
+	assert.Len(t, salt, SaltSize)
+	assert.Equal(t, salt, DeriveSalt(key, "att", CompressionNone, digest))
+	assert.NotEqual(t, salt, DeriveSalt(bytes.Repeat([]byte{3}, KeySize), "att", CompressionNone, digest))
+	assert.NotEqual(t, salt, DeriveSalt(key, "other", CompressionNone, digest))
+	assert.NotEqual(t, salt, DeriveSalt(key, "att", CompressionGzip, digest))
+	assert.NotEqual(t, salt, DeriveSalt(key, "att", CompressionNone, bytes.Repeat([]byte{4}, 32)))
+}
@@ -0,0 +1,31 @@
+package internal
+
+import "errors"
+
+// ErrCorrupt is the category of errors reporting invalid or tampered attachment data.
+// The ember package exports it, so that errors of this package can be matched against it.
+var ErrCorrupt = errors.New("corrupt attachment data")
+
+// ErrChecksumMismatch is returned if the content of an attachment does not match its digest.
+// It is shared by the ember and embedding packages, and matches ErrCorrupt.
+var ErrChecksumMismatch error = &categorizedErr{"corrupt attachment data (checksum mismatch)", ErrCorrupt}
+
+// NewCategorizedErr returns a sentinel error that also matches a broader category via errors.Is.
+// The ember package uses it for its sentinel errors as well.
+func NewCategorizedErr(msg string, category error) error {
+	return &categorizedErr{msg, category}
+}
+
+// categorizedErr is a sentinel error that also matches a broader category via errors.Is.
+type categorizedErr struct {
+	msg      string
+	category error
+}
+
+func (e *categorizedErr) Error() string {
+	return e.msg
+}
+
+func (e *categorizedErr) Unwrap() error {
+	return e.category
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// footerMagic is located at the very end of the footer and identifies it.
+var footerMagic = []byte{'~', 'e', 'm', 'b', 'e', 'r', 0x0F, '~'}
+
+// FooterSize is the size of the serialized footer in bytes.
+const FooterSize = 32
+
+// FooterVersion is the current version of the footer format.
+//
+//	Version 1: The footer directly follows the trailing boundary.
+//	Version 2: An optional signature block is located between the trailing boundary and the footer.
+const FooterVersion = 2
+
+// Footer is appended to the very end of an augmented executable, after the trailing boundary and signature block.
+// It allows locating the TOC with a single read from the end of the file, instead of scanning the whole executable.
+//
+// Layout (little endian):
+//
+//	TOC offset (int64) | TOC size (int64) | version (uint16) | reserved (6 bytes) | magic (8 bytes)
+type Footer struct {
+	TOCOffset int64  // Absolute position of the TOC, in relation to the start of the file
+	TOCSize   int64  // Size of the TOC in bytes (without boundaries)
+	Version   uint16 // Format version
+}
+
+// WriteFooter writes the serialized footer.
+func WriteFooter(w io.Writer, f Footer) error {
+	buf := make([]byte, FooterSize)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(f.TOCOffset))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(f.TOCSize))
+	binary.LittleEndian.PutUint16(buf[16:], f.Version)
+	copy(buf[FooterSize-len(footerMagic):], footerMagic)
+
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseFooter parses a serialized footer.
+// Returns false if the data does not contain a footer.
+func ParseFooter(data []byte) (Footer, bool) {
+	if len(data) != FooterSize || !bytes.Equal(data[FooterSize-len(footerMagic):], footerMagic) {
+		return Footer{}, false
+	}
+	return Footer{
+		TOCOffset: int64(binary.LittleEndian.Uint64(data[0:])),
+		TOCSize:   int64(binary.LittleEndian.Uint64(data[8:])),
+		Version:   binary.LittleEndian.Uint16(data[16:]),
+	}, true
+}
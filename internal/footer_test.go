@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFooter(t *testing.T) {
+	footer := Footer{
+		TOCOffset: 123456789,
+		TOCSize:   42,
+		Version:   FooterVersion,
+	}
+
+	var buf bytes.Buffer
+	err := WriteFooter(&buf, footer)
+	assert.NoError(t, err)
+	assert.Equal(t, FooterSize, buf.Len())
+
+	parsed, ok := ParseFooter(buf.Bytes())
+	assert.True(t, ok)
+	assert.Equal(t, footer, parsed)
+}
+
+func TestParseFooter_noFooter(t *testing.T) {
+	_, ok := ParseFooter(make([]byte, FooterSize))
+	assert.False(t, ok)
+
+	_, ok = ParseFooter([]byte("too short"))
+	assert.False(t, ok)
+}
+
+func TestWriteFooter_writeError(t *testing.T) {
+	err := WriteFooter(errWriter{}, Footer{})
+	assert.EqualError(t, err, "simulated error")
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidTOC is returned if a serialized TOC is malformed.
+	ErrInvalidTOC = errors.New("invalid TOC")
+	// ErrFormatTooNew is returned if a TOC was created by a newer, incompatible version of ember.
+	ErrFormatTooNew = errors.New("bundle format too new")
+	// ErrUnsupported is returned if an attachment uses an unknown compression or encryption scheme.
+	ErrUnsupported = errors.New("unsupported attachment format")
+)
+
+// EntryError records an error caused by a specific TOC entry.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("attachment %q: %v", e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// Index provides access to the entries of a parsed TOC.
+type Index interface {
+	// Len returns the number of entries.
+	Len() int
+	// Entry returns the entry at the given position, in the order of the attachment data.
+	// The returned offset is relative to the start of the first attachment.
+	Entry(i int) (Attachment, int64)
+	// Lookup returns the position of the entry with the given name.
+	Lookup(name string) (int, bool)
+	// DataSize returns the total size of all attachment data in bytes.
+	DataSize() int64
+}
+
+// ParseTOC parses and validates a serialized TOC.
+// Both the binary format and the JSON format of older ember versions are supported.
+//
+// Returns ErrFormatTooNew if the TOC was created by a newer version of ember.
+func ParseTOC(data []byte) (Index, error) {
+	if IsBinaryTOC(data) {
+		return parseBinaryTOC(data)
+	}
+	return parseJSONTOC(data)
+}
+
+// checkSupported ensures that the attachment's compression and encryption schemes are known.
+func checkSupported(a *Attachment) error {
+	if !IsSupportedCompression(a.Compression) {
+		return &EntryError{a.Name, fmt.Errorf("%w (compression %q)", ErrUnsupported, a.Compression)}
+	}
+	if !IsSupportedEncryption(a.Encryption) {
+		return &EntryError{a.Name, fmt.Errorf("%w (encryption %q)", ErrUnsupported, a.Encryption)}
+	}
+	return nil
+}
+
+// jsonIndex is the fully decoded JSON TOC (format version 1).
+type jsonIndex struct {
+	toc      TOC
+	offsets  []int64
+	names    map[string]int
+	dataSize int64
+}
+
+func parseJSONTOC(data []byte) (*jsonIndex, error) {
+	var toc TOC
+	if err := json.Unmarshal(data, &toc); err != nil {
+		return nil, ErrInvalidTOC
+	}
+	idx := &jsonIndex{
+		toc:     toc,
+		offsets: make([]int64, len(toc)),
+		names:   make(map[string]int, len(toc)),
+	}
+	for i := range toc {
+		a := &toc[i]
+		if a.Size < 0 || a.StoredSize < 0 {
+			return nil, ErrInvalidTOC
+		}
+		if err := checkSupported(a); err != nil {
+			return nil, err
+		}
+		idx.offsets[i] = idx.dataSize
+		idx.names[a.Name] = i
+		idx.dataSize += a.Stored()
+	}
+	return idx, nil
+}
+
+func (t *jsonIndex) Len() int {
+	return len(t.toc)
+}
+
+func (t *jsonIndex) Entry(i int) (Attachment, int64) {
+	return t.toc[i], t.offsets[i]
+}
+
+func (t *jsonIndex) Lookup(name string) (int, bool) {
+	i, ok := t.names[name]
+	return i, ok
+}
+
+func (t *jsonIndex) DataSize() int64 {
+	return t.dataSize
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"io"
+)
+
+// signatureMagic is located at the start of the signature block and identifies it.
+var signatureMagic = []byte{'~', 'e', 's', 'i', 'g', 0x0F, 0x01, '~'}
+
+// SignatureSize is the size of the serialized signature block in bytes.
+const SignatureSize = 8 + ed25519.PublicKeySize + ed25519.SignatureSize
+
+// signaturePrefix is prepended to the signed data for domain separation.
+const signaturePrefix = "maja42/ember signature v1\x00"
+
+// layerSignaturePrefix is prepended to the signed data of layers that were appended to existing attachments.
+const layerSignaturePrefix = "maja42/ember layer signature v1\x00"
+
+// Signature is an optional block located between the trailing boundary and the footer.
+// It contains an Ed25519 signature over the TOC (which includes the digests of all attachments).
+// Signatures of appended layers also cover the digest of the previous layer's TOC,
+// so layers can not be removed from the middle, or moved onto different attachments.
+//
+// Layout:
+//
+//	magic (8 bytes) | public key (32 bytes) | signature (64 bytes)
+type Signature struct {
+	PublicKey ed25519.PublicKey // Key used for signing
+	Signature []byte            // Signature over SignedData(TOC, previous TOC)
+}
+
+// SignedData returns the data that is signed for the given serialized TOC.
+// prevTOC is the serialized TOC of the previous layer, or nil for the first layer.
+func SignedData(toc, prevTOC []byte) []byte {
+	if prevTOC == nil {
+		data := make([]byte, 0, len(signaturePrefix)+len(toc))
+		data = append(data, signaturePrefix...)
+		return append(data, toc...)
+	}
+	prevDigest := sha256.Sum256(prevTOC)
+	data := make([]byte, 0, len(layerSignaturePrefix)+len(prevDigest)+len(toc))
+	data = append(data, layerSignaturePrefix...)
+	data = append(data, prevDigest[:]...)
+	return append(data, toc...)
+}
+
+// Sign creates the signature block for the given serialized TOC.
+// prevTOC is the serialized TOC of the previous layer, or nil for the first layer.
+func Sign(key ed25519.PrivateKey, toc, prevTOC []byte) Signature {
+	return Signature{
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, SignedData(toc, prevTOC)),
+	}
+}
+
+// Verify checks if the signature is valid for the given serialized TOC and the TOC of the previous layer.
+func (s Signature) Verify(toc, prevTOC []byte) bool {
+	return ed25519.Verify(s.PublicKey, SignedData(toc, prevTOC), s.Signature)
+}
+
+// WriteSignature writes the serialized signature block.
+func WriteSignature(w io.Writer, s Signature) error {
+	buf := make([]byte, 0, SignatureSize)
+	buf = append(buf, signatureMagic...)
+	buf = append(buf, s.PublicKey...)
+	buf = append(buf, s.Signature...)
+
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseSignature parses a serialized signature block.
+// Returns false if the data does not contain a signature block.
+func ParseSignature(data []byte) (Signature, bool) {
+	if len(data) != SignatureSize || !bytes.Equal(data[:len(signatureMagic)], signatureMagic) {
+		return Signature{}, false
+	}
+	data = data[len(signatureMagic):]
+	return Signature{
+		PublicKey: ed25519.PublicKey(data[:ed25519.PublicKeySize]),
+		Signature: data[ed25519.PublicKeySize:],
+	}, true
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	toc := []byte(`[{"Name":"att","Size":7}]`)
+	sig := Sign(priv, toc, nil)
+	assert.True(t, sig.Verify(toc, nil))
+	assert.False(t, sig.Verify([]byte(`[{"Name":"att","Size":8}]`), nil))
+
+	var buf bytes.Buffer
+	err = WriteSignature(&buf, sig)
+	assert.NoError(t, err)
+	assert.Equal(t, SignatureSize, buf.Len())
+
+	parsed, ok := ParseSignature(buf.Bytes())
+	assert.True(t, ok)
+	assert.Equal(t, sig.PublicKey, parsed.PublicKey)
+	assert.Equal(t, sig.Signature, parsed.Signature)
+	assert.True(t, parsed.Verify(toc, nil))
+}
+
+func TestSignature_layer(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	prevTOC := []byte(`[{"Name":"base","Size":4}]`)
+	toc := []byte(`[{"Name":"att","Size":7}]`)
+	sig := Sign(priv, toc, prevTOC)
+	assert.True(t, sig.Verify(toc, prevTOC))
+	assert.False(t, sig.Verify(toc, nil), "first layer")
+	assert.False(t, sig.Verify(toc, []byte(`[{"Name":"base","Size":5}]`)), "different previous layer")
+	assert.False(t, Sign(priv, toc, nil).Verify(toc, prevTOC))
+}
+
+func TestParseSignature_noSignature(t *testing.T) {
+	_, ok := ParseSignature(make([]byte, SignatureSize))
+	assert.False(t, ok)
+
+	_, ok = ParseSignature([]byte("too short"))
+	assert.False(t, ok)
+}
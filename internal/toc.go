@@ -1,12 +1,40 @@
 package internal
 
-// TOC (=table of content) lists all attachments of an executable.
+// TOC (=table of content) lists all attachments of a layer.
 // The order of attachments in the TOC reflects the order of attachment data afterwards.
-// The TOC is embedded as json prior to the first attachment, guarded by a boundary byte-pattern on both sides.
+// The TOC is embedded prior to the first attachment, guarded by a boundary byte-pattern on both sides.
+// It is serialized in the binary format (see EncodeTOC), or as json by older versions of ember.
+//
+// An executable can contain multiple layers, each consisting of its own TOC, attachment data and footer.
+// Each layer directly follows the previous one; attachments of later layers override earlier ones.
 type TOC []Attachment
 
 // Attachment represents a single embedded resource.
 type Attachment struct {
 	Name string // Resource name
 	Size int64  // Resource size in bytes
+
+	Digest []byte `json:",omitempty"` // SHA-256 digest (HMAC-SHA256 if encrypted) of the resource content (optional)
+
+	Compression string `json:",omitempty"` // Compression codec of the stored data (optional)
+	StoredSize  int64  `json:",omitempty"` // Size of the stored data in bytes, if it differs from Size (eg. due to compression)
+
+	Encryption string `json:",omitempty"` // Encryption scheme of the stored data (optional). Encryption is applied after compression
+	KeyID      string `json:",omitempty"` // Identifies the key used for encryption
+	Salt       []byte `json:",omitempty"` // Salt for deriving the per-attachment keys
+
+	Mode        uint32            `json:",omitempty"` // File mode bits (optional)
+	ModTime     int64             `json:",omitempty"` // Modification time in nanoseconds since the unix epoch (optional)
+	ContentType string            `json:",omitempty"` // MIME type (optional)
+	Labels      map[string]string `json:",omitempty"` // Arbitrary key-value pairs (optional)
+
+	Tombstone bool `json:",omitempty"` // Removes the attachment of a previous layer; there is no data
+}
+
+// Stored returns the number of bytes the resource occupies within the executable.
+func (a *Attachment) Stored() int64 {
+	if a.StoredSize != 0 {
+		return a.StoredSize
+	}
+	return a.Size
 }
@@ -0,0 +1,469 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// tocMagic is located at the very start of binary TOCs and identifies them.
+var tocMagic = []byte{'~', 'e', 't', 'o', 'c', 0x0F, 0x01, '~'}
+
+// TOCVersion is the current version of the TOC format.
+//
+//	Version 1: JSON array of attachments (no header).
+//	Version 2: Binary encoding with a name-sorted index.
+const TOCVersion = 2
+
+// TOCHeaderSize is the size of the binary TOC header in bytes.
+const TOCHeaderSize = 32
+
+// Binary TOC layout (little endian):
+//
+//	header:  magic (8 bytes) | version (uint16) | flags (uint16) | entry count (uint32) | TOC size (uint64) | data size (uint64)
+//	records: offset of each record relative to the TOC start (count * uint32), in the order of the attachment data
+//	index:   record numbers sorted by attachment name (count * uint32)
+//	records: name | size | stored size | data offset | optional fields | 0
+//
+// Within records, numbers are encoded as uvarint, names and field values are prefixed with their length.
+// Optional fields are encoded as tag, length and value. Unknown tags are skipped.
+//
+// The header layout is the same for all versions, so that the size of newer TOCs is known and they can be rejected.
+// Flags are reserved for future extensions that must not be ignored; TOCs with unknown flags are rejected.
+
+// optional record fields
+const (
+	tagEnd = iota
+	tagDigest
+	tagCompression
+	tagEncryption
+	tagKeyID
+	tagSalt
+	tagMode
+	tagModTime
+	tagContentType
+	tagLabel // repeated for each label; key and value
+	tagTombstone
+)
+
+// IsBinaryTOC checks if the data starts with a binary TOC header.
+func IsBinaryTOC(data []byte) bool {
+	return len(data) >= len(tocMagic) && bytes.Equal(data[:len(tocMagic)], tocMagic)
+}
+
+// BinaryTOCSize returns the total size of the binary TOC with the given header.
+// Returns false if the data does not start with a binary TOC header.
+func BinaryTOCSize(header []byte) (int64, bool) {
+	if len(header) < TOCHeaderSize || !IsBinaryTOC(header) {
+		return 0, false
+	}
+	size := binary.LittleEndian.Uint64(header[16:])
+	if size > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(size), true
+}
+
+// EncodeTOC serializes the TOC in the binary format.
+// Attachment names must be unique.
+func EncodeTOC(toc TOC) ([]byte, error) {
+	records := make([][]byte, len(toc))
+	size := TOCHeaderSize + 8*len(toc)
+	var dataSize int64
+	for i := range toc {
+		records[i] = appendRecord(nil, &toc[i], dataSize)
+		size += len(records[i])
+		dataSize += toc[i].Stored()
+	}
+	if uint64(size) > math.MaxUint32 {
+		return nil, errors.New("TOC too large")
+	}
+
+	order := make([]int, len(toc))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return toc[order[i]].Name < toc[order[j]].Name
+	})
+	for j := 1; j < len(order); j++ {
+		if name := toc[order[j]].Name; name == toc[order[j-1]].Name {
+			return nil, fmt.Errorf("duplicate attachment %q", name)
+		}
+	}
+
+	buf := make([]byte, size)
+	copy(buf, tocMagic)
+	binary.LittleEndian.PutUint16(buf[8:], TOCVersion)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(toc)))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(size))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(dataSize))
+
+	pos := TOCHeaderSize + 8*len(toc)
+	for i, r := range records {
+		binary.LittleEndian.PutUint32(buf[TOCHeaderSize+4*i:], uint32(pos))
+		pos += copy(buf[pos:], r)
+	}
+	for j, i := range order {
+		binary.LittleEndian.PutUint32(buf[TOCHeaderSize+4*(len(toc)+j):], uint32(i))
+	}
+	return buf, nil
+}
+
+// appendRecord appends the serialized attachment.
+func appendRecord(buf []byte, a *Attachment, offset int64) []byte {
+	buf = appendBytes(buf, []byte(a.Name))
+	buf = appendUvarint(buf, uint64(a.Size))
+	buf = appendUvarint(buf, uint64(a.StoredSize))
+	buf = appendUvarint(buf, uint64(offset))
+
+	field := func(tag int, value []byte) {
+		buf = appendUvarint(buf, uint64(tag))
+		buf = appendBytes(buf, value)
+	}
+	if len(a.Digest) > 0 {
+		field(tagDigest, a.Digest)
+	}
+	if a.Compression != "" {
+		field(tagCompression, []byte(a.Compression))
+	}
+	if a.Encryption != "" {
+		field(tagEncryption, []byte(a.Encryption))
+	}
+	if a.KeyID != "" {
+		field(tagKeyID, []byte(a.KeyID))
+	}
+	if len(a.Salt) > 0 {
+		field(tagSalt, a.Salt)
+	}
+	if a.Mode != 0 {
+		field(tagMode, appendUvarint(nil, uint64(a.Mode)))
+	}
+	if a.ModTime != 0 {
+		field(tagModTime, appendVarint(nil, a.ModTime))
+	}
+	if a.ContentType != "" {
+		field(tagContentType, []byte(a.ContentType))
+	}
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		field(tagLabel, append(appendBytes(nil, []byte(k)), a.Labels[k]...))
+	}
+	if a.Tombstone {
+		field(tagTombstone, nil)
+	}
+	return appendUvarint(buf, tagEnd)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// binaryIndex provides access to a binary TOC (format version 2) without copying it.
+// Records are validated by parseBinaryTOC, but only converted into attachments when accessed;
+// names are looked up via binary search.
+type binaryIndex struct {
+	data     []byte
+	count    int
+	dataSize int64
+}
+
+// parseBinaryTOC validates the binary TOC.
+// Every record is decoded and checked once, together with the order of the name index,
+// so that all later accesses succeed.
+func parseBinaryTOC(data []byte) (*binaryIndex, error) {
+	if len(data) < TOCHeaderSize {
+		return nil, ErrInvalidTOC
+	}
+	version := binary.LittleEndian.Uint16(data[8:])
+	flags := binary.LittleEndian.Uint16(data[10:])
+	if version > TOCVersion || flags != 0 {
+		return nil, fmt.Errorf("%w (version %d)", ErrFormatTooNew, version)
+	}
+	if version < TOCVersion {
+		return nil, ErrInvalidTOC
+	}
+	count := uint64(binary.LittleEndian.Uint32(data[12:]))
+	tocSize := binary.LittleEndian.Uint64(data[16:])
+	dataSize := binary.LittleEndian.Uint64(data[24:])
+	tablesEnd := TOCHeaderSize + 8*count
+	if tocSize != uint64(len(data)) || tablesEnd > tocSize || dataSize > math.MaxInt64 {
+		return nil, ErrInvalidTOC
+	}
+
+	t := &binaryIndex{
+		data:     data,
+		count:    int(count),
+		dataSize: int64(dataSize),
+	}
+	var offset int64
+	for i := 0; i < t.count; i++ {
+		pos := t.recordPos(i)
+		if pos < int(tablesEnd) || pos >= len(data) {
+			return nil, ErrInvalidTOC
+		}
+		r, err := parseRecord(data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		stored := r.stored()
+		if r.offset != offset || stored > math.MaxInt64-offset {
+			return nil, ErrInvalidTOC
+		}
+		offset += stored
+	}
+	if offset != t.dataSize {
+		return nil, ErrInvalidTOC
+	}
+
+	var prev []byte
+	for j := 0; j < t.count; j++ {
+		i := t.indexed(j)
+		if i >= t.count {
+			return nil, ErrInvalidTOC
+		}
+		name := t.name(i)
+		if j > 0 && bytes.Compare(prev, name) >= 0 { // must be sorted and unique
+			return nil, ErrInvalidTOC
+		}
+		prev = name
+	}
+	return t, nil
+}
+
+// recordPos returns the position of the i-th record within the TOC.
+func (t *binaryIndex) recordPos(i int) int {
+	return int(binary.LittleEndian.Uint32(t.data[TOCHeaderSize+4*i:]))
+}
+
+// indexed returns the record number at the given position of the name index.
+func (t *binaryIndex) indexed(j int) int {
+	return int(binary.LittleEndian.Uint32(t.data[TOCHeaderSize+4*(t.count+j):]))
+}
+
+// name returns the name of the i-th record.
+func (t *binaryIndex) name(i int) []byte {
+	d := decoder{data: t.data[t.recordPos(i):]}
+	return d.bytes()
+}
+
+func (t *binaryIndex) Len() int {
+	return t.count
+}
+
+func (t *binaryIndex) Entry(i int) (Attachment, int64) {
+	r, _ := parseRecord(t.data[t.recordPos(i):]) // validated during parsing
+	return r.attachment(), r.offset
+}
+
+func (t *binaryIndex) Lookup(name string) (int, bool) {
+	j := sort.Search(t.count, func(j int) bool {
+		return string(t.name(t.indexed(j))) >= name
+	})
+	if j == t.count {
+		return 0, false
+	}
+	i := t.indexed(j)
+	return i, string(t.name(i)) == name
+}
+
+func (t *binaryIndex) DataSize() int64 {
+	return t.dataSize
+}
+
+// record is a serialized TOC entry. Slices reference the TOC data.
+type record struct {
+	name       []byte
+	size       int64
+	storedSize int64
+	offset     int64
+	fields     []byte // encoded optional fields, including the terminating tag
+}
+
+// stored returns the number of bytes the attachment occupies within the executable.
+func (r *record) stored() int64 {
+	if r.storedSize != 0 {
+		return r.storedSize
+	}
+	return r.size
+}
+
+// parseRecord parses and validates the record at the start of data.
+func parseRecord(data []byte) (record, error) {
+	d := decoder{data: data}
+	r := record{
+		name:       d.bytes(),
+		size:       d.int64(),
+		storedSize: d.int64(),
+		offset:     d.int64(),
+	}
+	fields := d.data
+	for !d.err {
+		tag := d.uvarint()
+		if tag == tagEnd {
+			break
+		}
+		value := d.bytes()
+		if d.err || !validField(tag, value) {
+			return record{}, ErrInvalidTOC
+		}
+		if err := checkSupportedField(tag, value); err != nil {
+			return record{}, &EntryError{string(r.name), err}
+		}
+	}
+	if d.err {
+		return record{}, ErrInvalidTOC
+	}
+	r.fields = fields[:len(fields)-len(d.data)]
+	return r, nil
+}
+
+// validField checks if the value of a field can be decoded.
+func validField(tag uint64, value []byte) bool {
+	d := decoder{data: value}
+	switch tag {
+	case tagMode:
+		v := d.uvarint()
+		return !d.err && len(d.data) == 0 && v <= math.MaxUint32
+	case tagModTime:
+		d.varint()
+		return !d.err && len(d.data) == 0
+	case tagLabel:
+		d.bytes()
+		return !d.err
+	}
+	return true
+}
+
+// checkSupportedField ensures that the compression and encryption schemes are known.
+func checkSupportedField(tag uint64, value []byte) error {
+	switch tag {
+	case tagCompression:
+		if !IsSupportedCompression(string(value)) {
+			return fmt.Errorf("%w (compression %q)", ErrUnsupported, value)
+		}
+	case tagEncryption:
+		if !IsSupportedEncryption(string(value)) {
+			return fmt.Errorf("%w (encryption %q)", ErrUnsupported, value)
+		}
+	}
+	return nil
+}
+
+// attachment decodes the record. It must have been validated by parseRecord.
+func (r *record) attachment() Attachment {
+	a := Attachment{
+		Name:       string(r.name),
+		Size:       r.size,
+		StoredSize: r.storedSize,
+	}
+	d := decoder{data: r.fields}
+	for {
+		tag := d.uvarint()
+		if tag == tagEnd {
+			break
+		}
+		value := d.bytes()
+		switch tag {
+		case tagDigest:
+			a.Digest = append([]byte(nil), value...)
+		case tagCompression:
+			a.Compression = string(value)
+		case tagEncryption:
+			a.Encryption = string(value)
+		case tagKeyID:
+			a.KeyID = string(value)
+		case tagSalt:
+			a.Salt = append([]byte(nil), value...)
+		case tagMode:
+			v, _ := binary.Uvarint(value)
+			a.Mode = uint32(v)
+		case tagModTime:
+			a.ModTime, _ = binary.Varint(value)
+		case tagContentType:
+			a.ContentType = string(value)
+		case tagLabel:
+			l := decoder{data: value}
+			key := l.bytes()
+			if a.Labels == nil {
+				a.Labels = make(map[string]string)
+			}
+			a.Labels[string(key)] = string(l.data)
+		case tagTombstone:
+			a.Tombstone = true
+		}
+	}
+	return a
+}
+
+// decoder reads values from binary data.
+// After the first error, all reads return zero values.
+type decoder struct {
+	data []byte
+	err  bool
+}
+
+func (d *decoder) fail() {
+	d.data = nil
+	d.err = true
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int64() int64 {
+	v := d.uvarint()
+	if v > math.MaxInt64 {
+		d.fail()
+		return 0
+	}
+	return int64(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTOC = TOC{
+	{Name: "zeta", Size: 10},
+	{
+		Name:        "alpha/config.json",
+		Size:        100,
+		Digest:      []byte{1, 2, 3},
+		Compression: CompressionGzip,
+		StoredSize:  42,
+		Encryption:  EncryptionAES256GCM,
+		KeyID:       "key",
+		Salt:        []byte{4, 5},
+		Mode:        0640,
+		ModTime:     -123456789,
+		ContentType: "application/json",
+		Labels:      map[string]string{"b": "2", "a": "1", "": ""},
+	},
+	{Name: "empty"},
+	{Name: "removed", Tombstone: true},
+}
+
+func TestEncodeTOC(t *testing.T) {
+	data, err := EncodeTOC(testTOC)
+	assert.NoError(t, err)
+	assert.True(t, IsBinaryTOC(data))
+
+	size, ok := BinaryTOCSize(data[:TOCHeaderSize])
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(data)), size)
+
+	index, err := ParseTOC(data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(testTOC), index.Len())
+	assert.Equal(t, int64(52), index.DataSize())
+
+	var offset int64
+	for i, expected := range testTOC {
+		a, off := index.Entry(i)
+		assert.Equal(t, expected, a)
+		assert.Equal(t, offset, off)
+		offset += expected.Stored()
+
+		pos, ok := index.Lookup(expected.Name)
+		assert.True(t, ok)
+		assert.Equal(t, i, pos)
+	}
+
+	for _, name := range []string{"", "a", "beta", "zz", "alpha"} {
+		_, ok := index.Lookup(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestEncodeTOC_empty(t *testing.T) {
+	data, err := EncodeTOC(nil)
+	assert.NoError(t, err)
+	assert.Len(t, data, TOCHeaderSize)
+
+	index, err := ParseTOC(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, index.Len())
+	_, ok := index.Lookup("any")
+	assert.False(t, ok)
+}
+
+func TestEncodeTOC_duplicate(t *testing.T) {
+	_, err := EncodeTOC(TOC{{Name: "a"}, {Name: "b"}, {Name: "a"}})
+	assert.EqualError(t, err, `duplicate attachment "a"`)
+}
+
+func TestEncodeTOC_largeIndex(t *testing.T) {
+	toc := make(TOC, 5000)
+	for i := range toc {
+		toc[i] = Attachment{Name: fmt.Sprintf("file-%d", len(toc)-i), Size: int64(i)}
+	}
+	data, err := EncodeTOC(toc)
+	assert.NoError(t, err)
+	index, err := ParseTOC(data)
+	assert.NoError(t, err)
+
+	for _, i := range []int{0, 1, 2500, 4999} {
+		pos, ok := index.Lookup(toc[i].Name)
+		assert.True(t, ok)
+		assert.Equal(t, i, pos)
+	}
+}
+
+func TestParseTOC_json(t *testing.T) {
+	data, err := json.Marshal(testTOC)
+	assert.NoError(t, err)
+
+	index, err := ParseTOC(data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(testTOC), index.Len())
+	assert.Equal(t, int64(52), index.DataSize())
+
+	a, offset := index.Entry(2)
+	assert.Equal(t, testTOC[2], a)
+	assert.Equal(t, int64(52), offset)
+
+	pos, ok := index.Lookup("zeta")
+	assert.True(t, ok)
+	assert.Equal(t, 0, pos)
+	_, ok = index.Lookup("unknown")
+	assert.False(t, ok)
+
+	_, err = ParseTOC([]byte(`[{"Name":`))
+	assert.ErrorIs(t, err, ErrInvalidTOC)
+}
+
+func TestParseTOC_unsupported(t *testing.T) {
+	toc := TOC{{Name: "att", Compression: "lzma"}}
+	jsonTOC, err := json.Marshal(toc)
+	assert.NoError(t, err)
+	binTOC, err := EncodeTOC(toc)
+	assert.NoError(t, err)
+
+	for _, data := range [][]byte{jsonTOC, binTOC} {
+		_, err := ParseTOC(data)
+		assert.ErrorIs(t, err, ErrUnsupported)
+		assert.EqualError(t, err, `attachment "att": unsupported attachment format (compression "lzma")`)
+	}
+}
+
+func TestParseTOC_tooNew(t *testing.T) {
+	data, err := EncodeTOC(testTOC)
+	assert.NoError(t, err)
+
+	newer := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(newer[8:], TOCVersion+1)
+	_, err = ParseTOC(newer)
+	assert.ErrorIs(t, err, ErrFormatTooNew)
+
+	flagged := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(flagged[10:], 1)
+	_, err = ParseTOC(flagged)
+	assert.ErrorIs(t, err, ErrFormatTooNew)
+}
+
+func TestParseTOC_invalid(t *testing.T) {
+	data, err := EncodeTOC(testTOC)
+	assert.NoError(t, err)
+
+	modify := func(fn func(data []byte) []byte) []byte {
+		return fn(append([]byte(nil), data...))
+	}
+	tests := map[string][]byte{
+		"truncated header": data[:TOCHeaderSize-1],
+		"truncated":        data[:len(data)-1],
+		"old version": modify(func(d []byte) []byte {
+			binary.LittleEndian.PutUint16(d[8:], 1)
+			return d
+		}),
+		"entry count": modify(func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[12:], 1000)
+			return d
+		}),
+		"data size": modify(func(d []byte) []byte {
+			binary.LittleEndian.PutUint64(d[24:], 51)
+			return d
+		}),
+		"record offset": modify(func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[TOCHeaderSize:], uint32(len(d)))
+			return d
+		}),
+		"unsorted index": modify(func(d []byte) []byte {
+			idx := d[TOCHeaderSize+4*len(testTOC):]
+			copy(idx[0:4], idx[4:8])
+			return d
+		}),
+		"index out of range": modify(func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[TOCHeaderSize+4*len(testTOC):], 99)
+			return d
+		}),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTOC(data)
+			assert.ErrorIs(t, err, ErrInvalidTOC)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package ember
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/maja42/ember/internal"
+)
+
+// KeyProvider supplies the keys for decrypting attachments.
+type KeyProvider interface {
+	// Key returns the 32-byte key with the given ID, as chosen during embedding.
+	Key(keyID string) ([]byte, error)
+}
+
+// KeyProviderFunc is an adapter to allow the use of ordinary functions as KeyProvider,
+// eg. for deriving keys from other secrets.
+type KeyProviderFunc func(keyID string) ([]byte, error)
+
+// Key calls f(keyID).
+func (f KeyProviderFunc) Key(keyID string) ([]byte, error) {
+	return f(keyID)
+}
+
+// EnvKeyProvider returns the key stored in an environment variable, regardless of the requested key ID.
+// The key must be hex- or base64-encoded.
+func EnvKeyProvider(variable string) KeyProvider {
+	return KeyProviderFunc(func(string) ([]byte, error) {
+		value, ok := os.LookupEnv(variable)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %q is not set", variable)
+		}
+		return internal.ParseKey([]byte(value))
+	})
+}
+
+// FileKeyProvider returns the key stored in a file, regardless of the requested key ID.
+// The file contains either the raw key, or the hex- or base64-encoded key.
+func FileKeyProvider(path string) KeyProvider {
+	return KeyProviderFunc(func(string) ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return internal.ParseKey(data)
+	})
+}
+
+// WithKeyProvider configures the provider for keys that are needed to decrypt encrypted attachments.
+// Keys are requested on first use, and cached afterwards.
+func WithKeyProvider(p KeyProvider) Option {
+	return func(o *options) {
+		o.keyProvider = p
+	}
+}
+
+// ErrDecryption is returned if an encrypted attachment could not be decrypted,
+// because the key is wrong or the data is corrupt. It matches ErrCorrupt.
+var ErrDecryption = internal.ErrDecryption
+
+// ErrNoKeyProvider is returned when reading encrypted attachments without configuring a KeyProvider.
+var ErrNoKeyProvider = errors.New("attachment is encrypted, but no key provider is configured")
+
+// keyCache requests keys from the provider and caches them.
+type keyCache struct {
+	provider KeyProvider
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// key returns the key with the given ID.
+func (c *keyCache) key(keyID string) ([]byte, error) {
+	if c.provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[keyID]; ok {
+		return key, nil
+	}
+	key, err := c.provider.Key(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", keyID, err)
+	}
+	if len(key) != internal.KeySize {
+		return nil, fmt.Errorf("key %q: invalid key size %d (expected %d)", keyID, len(key), internal.KeySize)
+	}
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	c.keys[keyID] = key
+	return key, nil
+}
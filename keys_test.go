@@ -0,0 +1,65 @@
+package ember
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvKeyProvider(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("EMBER_TEST_KEY", hex.EncodeToString(key))
+
+	k, err := EnvKeyProvider("EMBER_TEST_KEY").Key("any")
+	assert.NoError(t, err)
+	assert.Equal(t, key, k)
+
+	_, err = EnvKeyProvider("EMBER_TEST_KEY_UNSET").Key("any")
+	assert.EqualError(t, err, `environment variable "EMBER_TEST_KEY_UNSET" is not set`)
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	key := []byte("\xff123456789abcdef0123456789abcdef") // raw, non-printable key
+	path := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(path, key, 0600))
+
+	k, err := FileKeyProvider(path).Key("any")
+	assert.NoError(t, err)
+	assert.Equal(t, key, k)
+
+	// printable text is not used as raw key
+	assert.NoError(t, os.WriteFile(path, []byte("0123456789abcdef0123456789abcdef"), 0600))
+	_, err = FileKeyProvider(path).Key("any")
+	assert.Error(t, err)
+
+	_, err = FileKeyProvider(path + ".missing").Key("any")
+	assert.Error(t, err)
+}
+
+func TestKeyCache(t *testing.T) {
+	calls := 0
+	cache := keyCache{
+		provider: KeyProviderFunc(func(keyID string) ([]byte, error) {
+			calls++
+			if keyID == "short" {
+				return []byte("short"), nil
+			}
+			return []byte("0123456789abcdef0123456789abcdef"), nil
+		}),
+	}
+
+	_, err := cache.key("id")
+	assert.NoError(t, err)
+	_, err = cache.key("id")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	_, err = cache.key("short")
+	assert.EqualError(t, err, `key "short": invalid key size 5 (expected 32)`)
+
+	_, err = (&keyCache{}).key("id")
+	assert.Equal(t, ErrNoKeyProvider, err)
+}
@@ -0,0 +1,222 @@
+package ember
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Ensure that LayeredFS can be used as a file system.
+var (
+	_ fs.FS         = (*LayeredFS)(nil)
+	_ fs.ReadDirFS  = (*LayeredFS)(nil)
+	_ fs.ReadFileFS = (*LayeredFS)(nil)
+	_ fs.StatFS     = (*LayeredFS)(nil)
+)
+
+// Layer identifies the origin of a file served by a LayeredFS.
+type Layer int
+
+const (
+	// LayerBase is the base file system, typically an embed.FS containing compile-time defaults.
+	LayerBase Layer = iota + 1
+	// LayerAttachments are the attachments embedded into the executable.
+	LayerAttachments
+	// LayerOverlay is the overlay directory of the attachments (see WithOverlay).
+	LayerOverlay
+)
+
+func (l Layer) String() string {
+	switch l {
+	case LayerBase:
+		return "base"
+	case LayerAttachments:
+		return "attachments"
+	case LayerOverlay:
+		return "overlay"
+	}
+	return "unknown"
+}
+
+// LayeredFS is a file system that serves attachments on top of a base file system.
+// Each name is resolved against the attachments first; the base file system is used as fallback.
+// Directories contain the files of both layers.
+//
+// This allows shipping defaults via go:embed, which can be customized by embedding attachments with the same name.
+type LayeredFS struct {
+	base fs.FS
+	att  *Attachments
+}
+
+// NewLayeredFS returns a file system that serves the given attachments on top of the base file system.
+//
+// See LayeredFS for more information.
+func NewLayeredFS(base fs.FS, att *Attachments) *LayeredFS {
+	return &LayeredFS{
+		base: base,
+		att:  att,
+	}
+}
+
+// Open opens the named file or directory.
+//
+// Open implements fs.FS.
+func (l *LayeredFS) Open(name string) (fs.File, error) {
+	attInfo, baseInfo, err := l.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case attInfo != nil && !attInfo.IsDir():
+		return l.att.Open(name)
+	case attInfo == nil && !baseInfo.IsDir():
+		return l.base.Open(name)
+	}
+	entries, err := l.readDir(name, attInfo, baseInfo)
+	if err != nil {
+		return nil, err
+	}
+	info := attInfo
+	if info == nil {
+		info = baseInfo
+	}
+	return &dir{
+		info:    info,
+		entries: entries,
+	}, nil
+}
+
+// ReadDir reads the named directory and returns a list of directory entries sorted by filename.
+// The entries of both layers are combined.
+//
+// ReadDir implements fs.ReadDirFS.
+func (l *LayeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	attInfo, baseInfo, err := l.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if (attInfo != nil && !attInfo.IsDir()) || (attInfo == nil && !baseInfo.IsDir()) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return l.readDir(name, attInfo, baseInfo)
+}
+
+// readDir combines the entries of the named directory in both layers.
+// Entries of the attachments take precedence.
+func (l *LayeredFS) readDir(name string, attInfo, baseInfo fs.FileInfo) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	if baseInfo != nil && baseInfo.IsDir() {
+		list, err := fs.ReadDir(l.base, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			entries[e.Name()] = e
+		}
+	}
+	if attInfo != nil {
+		list, err := l.att.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			entries[e.Name()] = e
+		}
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+//
+// ReadFile implements fs.ReadFileFS.
+func (l *LayeredFS) ReadFile(name string) ([]byte, error) {
+	attInfo, baseInfo, err := l.stat("read", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case attInfo != nil && !attInfo.IsDir():
+		return l.att.ReadFile(name)
+	case attInfo == nil && !baseInfo.IsDir():
+		return fs.ReadFile(l.base, name)
+	}
+	return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+//
+// Stat implements fs.StatFS.
+func (l *LayeredFS) Stat(name string) (fs.FileInfo, error) {
+	attInfo, baseInfo, err := l.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if attInfo != nil {
+		return attInfo, nil
+	}
+	return baseInfo, nil
+}
+
+// Origin returns the layer that serves the named file.
+// For directories, the top-most layer containing the directory is returned.
+func (l *LayeredFS) Origin(name string) (Layer, error) {
+	attInfo, _, err := l.stat("origin", name)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case attInfo == nil:
+		return LayerBase, nil
+	case l.att.FromOverlay(name):
+		return LayerOverlay, nil
+	}
+	return LayerAttachments, nil
+}
+
+// stat returns the FileInfo of the named file or directory within each layer.
+// The FileInfo is nil if the layer does not contain the name.
+// Fails with fs.ErrNotExist if neither layer contains it.
+func (l *LayeredFS) stat(op, name string) (attInfo, baseInfo fs.FileInfo, err error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if attInfo, err = l.att.Stat(name); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+		attInfo = nil
+	}
+	if attInfo == nil && l.hiddenByAttachment(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if baseInfo, err = fs.Stat(l.base, name); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+		baseInfo = nil
+	}
+	if attInfo == nil && baseInfo == nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return attInfo, baseInfo, nil
+}
+
+// hiddenByAttachment reports whether a parent directory of name is replaced by an attachment,
+// which hides the directory of the base file system.
+func (l *LayeredFS) hiddenByAttachment(name string) bool {
+	for p := path.Dir(name); p != "."; p = path.Dir(p) {
+		if info, err := l.att.Stat(p); err == nil {
+			return !info.IsDir()
+		}
+	}
+	return false
+}
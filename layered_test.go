@@ -0,0 +1,95 @@
+package ember
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayeredFS(t *testing.T) {
+	base := fstest.MapFS{
+		"default.txt":          {Data: []byte("default")},
+		"config/app.json":      {Data: []byte(`{"default":true}`)},
+		"config/log.json":      {Data: []byte(`{"level":"info"}`)},
+		"templates/mail.tmpl":  {Data: []byte("Hello")},
+		"replaced/by/file.txt": {Data: []byte("hidden")},
+	}
+	att := prepareFS(t, map[string]string{
+		"config/app.json": `{"default":false}`,
+		"config/db.json":  `{}`,
+		"custom.txt":      "custom",
+		"replaced":        "file",
+	})
+	layered := NewLayeredFS(base, att)
+
+	err := fstest.TestFS(layered,
+		"default.txt",
+		"config/app.json",
+		"config/log.json",
+		"config/db.json",
+		"templates/mail.tmpl",
+		"custom.txt",
+		"replaced",
+	)
+	assert.NoError(t, err)
+
+	data, err := fs.ReadFile(layered, "config/app.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"default":false}`, string(data))
+
+	data, err = fs.ReadFile(layered, "config/log.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"info"}`, string(data))
+
+	_, err = fs.ReadFile(layered, "replaced/by/file.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	entries, err := fs.ReadDir(layered, "config")
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"app.json", "db.json", "log.json"}, names)
+
+	t.Run("Origin()", func(t *testing.T) {
+		for name, expected := range map[string]Layer{
+			"default.txt":     LayerBase,
+			"config/app.json": LayerAttachments,
+			"config/log.json": LayerBase,
+			"custom.txt":      LayerAttachments,
+			"templates":       LayerBase,
+			"config":          LayerAttachments,
+		} {
+			layer, err := layered.Origin(name)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, layer, name)
+		}
+
+		_, err := layered.Origin("unknown")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = layered.Origin("../invalid")
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+	})
+}
+
+func TestLayeredFS_overlay(t *testing.T) {
+	base := fstest.MapFS{
+		"default.txt": {Data: []byte("default")},
+	}
+	overlay := prepareOverlay(t, map[string]string{"default.txt": "overlay"})
+	att, err := NewReader(nil, 0, WithOverlay(overlay))
+	assert.NoError(t, err)
+	layered := NewLayeredFS(base, att)
+
+	data, err := fs.ReadFile(layered, "default.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "overlay", string(data))
+
+	layer, err := layered.Origin("default.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, LayerOverlay, layer)
+	assert.Equal(t, "overlay", layer.String())
+}
@@ -0,0 +1,45 @@
+package ember
+
+import (
+	"mime"
+	"path"
+)
+
+// ContentType returns the MIME type of a specific attachment.
+// If no content type was stored during embedding, it is guessed based on the attachment's file extension.
+// Returns an empty string if the content type is unknown, or if no attachment with that name exists.
+func (a *Attachments) ContentType(name string) string {
+	e, ok := a.entry(name)
+	if !ok {
+		return ""
+	}
+	if e.ContentType != "" {
+		return e.ContentType
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// Label returns the value of a label stored alongside a specific attachment.
+// Returns false if the label does not exist, or if no attachment with that name exists.
+func (a *Attachments) Label(name, key string) (string, bool) {
+	e, ok := a.entry(name)
+	if !ok {
+		return "", false
+	}
+	value, ok := e.Labels[key]
+	return value, ok
+}
+
+// Labels returns all labels stored alongside a specific attachment.
+// Returns nil if there are no labels, or if no attachment with that name exists.
+func (a *Attachments) Labels(name string) map[string]string {
+	e, ok := a.entry(name)
+	if !ok || len(e.Labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(e.Labels))
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,59 @@
+package ember
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachments_Metadata(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+
+	var testTOC = internal.TOC{
+		{
+			Name:        "config/app.cfg",
+			Size:        2,
+			Mode:        0640,
+			ModTime:     modTime.UnixNano(),
+			ContentType: "application/json",
+			Labels:      map[string]string{"env": "prod"},
+		},
+		{Name: "index.html", Size: 2},
+	}
+	path := prepareFile(t, testTOC, [][]byte{[]byte("{}"), []byte("<>")})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	info, err := att.Stat("config/app.cfg")
+	assert.NoError(t, err)
+	assert.Equal(t, "app.cfg", info.Name())
+	assert.Equal(t, fs.FileMode(0640), info.Mode())
+	assert.True(t, modTime.Equal(info.ModTime()))
+
+	info, err = att.Stat("index.html")
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0444), info.Mode())
+	assert.True(t, info.ModTime().IsZero())
+
+	assert.Equal(t, "application/json", att.ContentType("config/app.cfg"))
+	assert.Equal(t, "text/html; charset=utf-8", att.ContentType("index.html"))
+	assert.Equal(t, "", att.ContentType("unknown"))
+
+	value, ok := att.Label("config/app.cfg", "env")
+	assert.True(t, ok)
+	assert.Equal(t, "prod", value)
+	_, ok = att.Label("config/app.cfg", "unknown")
+	assert.False(t, ok)
+	_, ok = att.Label("index.html", "env")
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]string{"env": "prod"}, att.Labels("config/app.cfg"))
+	assert.Nil(t, att.Labels("index.html"))
+}
@@ -0,0 +1,59 @@
+package ember
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// Option configures how attachments are opened.
+type Option func(*options)
+
+type options struct {
+	trustedKeys []ed25519.PublicKey
+	keyProvider KeyProvider
+	overlay     string
+	noOverlay   bool
+}
+
+func applyOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithTrustedKeys requires the attachments to be signed by one of the given Ed25519 public keys.
+// Opening fails if the attachments are unsigned, or if the signature is invalid.
+//
+// Since the signature covers the digests of all attachments, the content of every attachment
+// is read and verified while opening.
+//
+// If the executable contains multiple layers of attachments (see embedding.EmbedLayer), every layer must be signed.
+// The signature of each layer covers the previous layer, but removing trailing layers can not be detected.
+//
+// Executables without any attachments are rejected with ErrUnsigned as well, as the attachments might have been stripped.
+func WithTrustedKeys(keys ...ed25519.PublicKey) Option {
+	return func(o *options) {
+		o.trustedKeys = append(o.trustedKeys, keys...)
+	}
+}
+
+// ParsePublicKey parses a PEM-encoded Ed25519 public key, as created by the embedder's keygen command.
+func ParsePublicKey(pemData []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return edKey, nil
+}
@@ -0,0 +1,128 @@
+package ember
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// OverlayEnv is the environment variable that configures the overlay directory,
+// unless WithOverlay or WithoutOverlay is used.
+// It is ignored if trusted keys are configured (see WithTrustedKeys).
+const OverlayEnv = "EMBER_OVERLAY"
+
+// errUntrustedOverlay is returned if both an overlay directory and trusted keys are configured.
+var errUntrustedOverlay = errors.New("an overlay directory can not be used together with trusted keys")
+
+// WithOverlay uses the files within the given directory as an overlay.
+//
+// Overlay files are treated like attachments, using their '/'-separated path relative to dir as name.
+// They shadow embedded attachments with the same name, and supplement the rest.
+// This allows running applications during development (eg. via "go run") without embedding any files.
+//
+// The directory is scanned when opening the attachments, but the content, size and mode of overlay files are read whenever they are accessed.
+// Overlay files are not covered by digests and signatures. Verifying them fails with ErrNoDigest,
+// and opening fails if trusted keys are configured as well.
+func WithOverlay(dir string) Option {
+	return func(o *options) {
+		o.overlay = dir
+	}
+}
+
+// WithoutOverlay disables the overlay, even if the EMBER_OVERLAY environment variable is set.
+// Use this option in release builds to ensure that only embedded attachments are accessed.
+func WithoutOverlay() Option {
+	return func(o *options) {
+		o.noOverlay = true
+	}
+}
+
+// overlayDir returns the configured overlay directory, or an empty string if there is none.
+// Overlay files would bypass the signature, so they are not used together with trusted keys.
+func (o *options) overlayDir() (string, error) {
+	switch {
+	case o.noOverlay:
+		return "", nil
+	case o.overlay != "" && len(o.trustedKeys) > 0:
+		return "", errUntrustedOverlay
+	case o.overlay != "":
+		return o.overlay, nil
+	case len(o.trustedKeys) > 0:
+		return "", nil
+	}
+	return os.Getenv(OverlayEnv), nil
+}
+
+// applyOverlay adds all files within the overlay directory to the attachments, replacing existing ones.
+func (a *Attachments) applyOverlay(dir string) error {
+	overlay := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path) // follow symlinks
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		overlay[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.overlay = overlay
+	return nil
+}
+
+// overlayEntry describes the current state of an overlay file.
+// If the file can not be accessed anymore, the error is reported when reading it.
+func overlayEntry(name, path string) *entry {
+	e := &entry{overlay: path}
+	e.Name = name
+	if info, err := os.Stat(path); err == nil {
+		e.Size = info.Size()
+		e.Mode = uint32(info.Mode().Perm())
+		e.ModTime = info.ModTime().UnixNano()
+	}
+	return e
+}
+
+// FromOverlay reports whether an attachment is served from the overlay directory instead of the executable.
+// Returns false if no attachment with that name exists.
+func (a *Attachments) FromOverlay(name string) bool {
+	e, ok := a.entry(name)
+	return ok && e.overlay != ""
+}
+
+// overlayReader returns a reader for the current content of an overlay file.
+// The size is determined when the reader is created.
+func (a *Attachments) overlayReader(e *entry) (Reader, error) {
+	info, err := os.Stat(e.overlay)
+	if err != nil {
+		return nil, a.attErr(err, e)
+	}
+	return io.NewSectionReader(overlayFile(e.overlay), 0, info.Size()), nil
+}
+
+// overlayFile reads the overlay file with the given path.
+// The file is opened for each read, so that it is never kept open (and locked) while being edited.
+type overlayFile string
+
+func (f overlayFile) ReadAt(p []byte, off int64) (int, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.ReadAt(p, off)
+}
@@ -0,0 +1,122 @@
+package ember
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareOverlay creates an overlay directory with the given files.
+func prepareOverlay(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func readAll(t *testing.T, r io.Reader) string {
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestWithOverlay(t *testing.T) {
+	path := prepareFile(t, internal.TOC{
+		{Name: "embedded", Size: 8, Digest: digest("embedded")},
+		{Name: "shadowed", Size: 8, Digest: digest("embedded")},
+	}, [][]byte{[]byte("embedded"), []byte("embedded")})
+	defer os.Remove(path)
+
+	overlay := prepareOverlay(t, map[string]string{
+		"shadowed":    "overlay",
+		"dir/overlay": "overlay file",
+	})
+
+	att, err := OpenExe(path, WithOverlay(overlay))
+	assert.NoError(t, err)
+	defer att.Close()
+
+	list := att.List()
+	sort.Strings(list)
+	assert.Equal(t, []string{"dir/overlay", "embedded", "shadowed"}, list)
+
+	assert.Equal(t, "embedded", readAll(t, att.Reader("embedded")))
+	assert.Equal(t, "overlay", readAll(t, att.Reader("shadowed")))
+	assert.Equal(t, "overlay file", readAll(t, att.Reader("dir/overlay")))
+	assert.Equal(t, int64(7), att.Size("shadowed"))
+
+	assert.False(t, att.FromOverlay("embedded"))
+	assert.True(t, att.FromOverlay("shadowed"))
+	assert.True(t, att.FromOverlay("dir/overlay"))
+	assert.False(t, att.FromOverlay("unknown"))
+
+	assert.NoError(t, att.Verify("embedded"))
+	assert.ErrorIs(t, att.Verify("shadowed"), ErrNoDigest, "overlay files are not verified")
+
+	// changes to overlay files are visible immediately
+	assert.NoError(t, os.WriteFile(filepath.Join(overlay, "shadowed"), []byte("changed content"), 0600))
+	assert.Equal(t, "changed content", readAll(t, att.Reader("shadowed")))
+	assert.Equal(t, int64(15), att.Size("shadowed"))
+	assert.Equal(t, int64(15), att.Reader("shadowed").Size())
+	info, err := att.Stat("shadowed")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(15), info.Size())
+		assert.Equal(t, fs.FileMode(0644), info.Mode(), "mode of the existing file is kept")
+	}
+
+	// fs.FS
+	data, err := fs.ReadFile(att, "dir/overlay")
+	assert.NoError(t, err)
+	assert.Equal(t, "overlay file", string(data))
+
+	assert.NoError(t, att.Close())
+	_, err = att.Reader("shadowed").Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestWithOverlay_noAttachments(t *testing.T) {
+	overlay := prepareOverlay(t, map[string]string{"file": "content"})
+
+	att, err := NewReader(bytes.NewReader([]byte("executable")), 10, WithOverlay(overlay))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file"}, att.List())
+	assert.Equal(t, "content", readAll(t, att.Reader("file")))
+}
+
+func TestWithOverlay_missingDir(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("executable")), 10, WithOverlay(filepath.Join(t.TempDir(), "missing")))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestOverlayEnv(t *testing.T) {
+	overlay := prepareOverlay(t, map[string]string{"file": "content"})
+	t.Setenv(OverlayEnv, overlay)
+
+	att, err := NewReader(bytes.NewReader([]byte("executable")), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file"}, att.List())
+
+	att, err = NewReader(bytes.NewReader([]byte("executable")), 10, WithoutOverlay())
+	assert.NoError(t, err)
+	assert.Zero(t, att.Count())
+
+	att, err = NewReader(bytes.NewReader([]byte("executable")), 10, WithOverlay(overlay), WithoutOverlay())
+	assert.NoError(t, err)
+	assert.Zero(t, att.Count())
+
+	// overlay files would bypass the signature
+	pub, _, _ := ed25519.GenerateKey(nil)
+	_, err = NewReader(bytes.NewReader([]byte("executable")), 10, WithTrustedKeys(pub), WithOverlay(overlay))
+	assert.ErrorIs(t, err, errUntrustedOverlay)
+}
@@ -0,0 +1,148 @@
+package ember
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/maja42/ember/internal"
+)
+
+// errTruncatedData is returned if compressed data ends before the expected size was reached.
+var errTruncatedData error = internal.NewCategorizedErr("corrupt attachment data (compressed data truncated)", ErrCorrupt)
+
+// decompressingReader provides random access to compressed attachment data.
+// Data is decompressed sequentially; seeking backwards restarts decompression from the beginning.
+type decompressingReader struct {
+	mu     sync.Mutex
+	stored *io.SectionReader // compressed data
+	codec  string
+	size   int64 // uncompressed size
+
+	offset int64 // current position for Read and Seek
+
+	dec    io.ReadCloser // decompressor; nil if not yet started or already finished
+	decPos int64         // position of the decompressor within the uncompressed data
+}
+
+func newDecompressingReader(stored *io.SectionReader, codec string, size int64) *decompressingReader {
+	return &decompressingReader{
+		stored: stored,
+		codec:  codec,
+		size:   size,
+	}
+}
+
+// Size returns the uncompressed size.
+func (d *decompressingReader) Size() int64 {
+	return d.size
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, err := d.readAt(p, d.offset)
+	d.offset += int64(n)
+	return n, err
+}
+
+func (d *decompressingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, err := d.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (d *decompressingReader) Seek(offset int64, whence int) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+// readAt reads up to len(p) bytes, starting at the given position within the uncompressed data.
+// Returns io.EOF if the position is at or after the end of data.
+func (d *decompressingReader) readAt(p []byte, off int64) (int, error) {
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	if remaining := d.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if err := d.seekDecompressor(off); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(d.dec, p)
+	d.decPos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errTruncatedData
+	}
+	if err != nil || d.decPos == d.size {
+		_ = d.dec.Close()
+		d.dec = nil
+	}
+	return n, err
+}
+
+// seekDecompressor positions the decompressor at the given position within the uncompressed data.
+func (d *decompressingReader) seekDecompressor(off int64) error {
+	if d.dec != nil && off < d.decPos {
+		_ = d.dec.Close()
+		d.dec = nil
+	}
+	if d.dec == nil { // (re)start decompression
+		if _, err := d.stored.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		dec, err := internal.NewDecompressor(d.codec, d.stored)
+		if err != nil {
+			return err
+		}
+		d.dec = dec
+		d.decPos = 0
+	}
+
+	n, err := io.CopyN(io.Discard, d.dec, off-d.decPos)
+	d.decPos += n
+	if err == io.EOF {
+		err = errTruncatedData
+	}
+	if err != nil {
+		_ = d.dec.Close()
+		d.dec = nil
+	}
+	return err
+}
+
+// errReader fails all operations with the same error.
+type errReader struct {
+	err  error
+	size int64
+}
+
+func (e *errReader) Size() int64                       { return e.size }
+func (e *errReader) Read([]byte) (int, error)          { return 0, e.err }
+func (e *errReader) ReadAt([]byte, int64) (int, error) { return 0, e.err }
+func (e *errReader) Seek(int64, int) (int64, error)    { return 0, e.err }
@@ -0,0 +1,75 @@
+package ember
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func compress(t *testing.T, codec string, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := internal.NewCompressor(codec, &buf)
+	assert.NoError(t, err)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompressingReader(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+
+	for _, codec := range []string{internal.CompressionGzip, internal.CompressionZstd} {
+		t.Run(codec, func(t *testing.T) {
+			compressed := compress(t, codec, content)
+			assert.Less(t, len(compressed), len(content))
+
+			stored := io.NewSectionReader(bytes.NewReader(compressed), 0, int64(len(compressed)))
+			r := newDecompressingReader(stored, codec, int64(len(content)))
+			assert.Equal(t, int64(len(content)), r.Size())
+
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, content, data)
+
+			// seek backwards
+			pos, err := r.Seek(-15, io.SeekEnd)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(content)-15), pos)
+			data, err = io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, "567890123456789", string(data))
+
+			// random access
+			buf := make([]byte, 4)
+			n, err := r.ReadAt(buf, 1003)
+			assert.NoError(t, err)
+			assert.Equal(t, 4, n)
+			assert.Equal(t, "3456", string(buf))
+
+			n, err = r.ReadAt(buf, 2)
+			assert.NoError(t, err)
+			assert.Equal(t, "2345", string(buf[:n]))
+
+			n, err = r.ReadAt(buf, int64(len(content)-2))
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, "89", string(buf[:n]))
+		})
+	}
+}
+
+func TestDecompressingReader_truncated(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	compressed := compress(t, internal.CompressionGzip, content)
+
+	stored := io.NewSectionReader(bytes.NewReader(compressed), 0, int64(len(compressed)))
+	r := newDecompressingReader(stored, internal.CompressionGzip, int64(len(content))+10)
+
+	data, err := io.ReadAll(r)
+	assert.Equal(t, errTruncatedData, err)
+	assert.Equal(t, content, data)
+}
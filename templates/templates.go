@@ -0,0 +1,103 @@
+// Package templates parses text/template and html/template sets from attachments.
+//
+// It is a separate package, so applications that do not use templates are not affected by their binary size.
+package templates
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"path"
+	"sort"
+	texttemplate "text/template"
+
+	"github.com/maja42/ember"
+)
+
+// ParseText parses all attachments matching the given patterns into a new text/template set with the given name.
+// The syntax of patterns is the same as in path.Match; each pattern must match at least one attachment.
+//
+// Like template.ParseFS, each template is named after the base name of its attachment.
+// Attachments with the same base name (like "a/index.html" and "b/index.html") would redefine each other,
+// so they are reported as error.
+// All templates belong to the same set, so shared partials can be included by listing them as additional pattern.
+// Templates are parsed in the order of the patterns; matches of a single pattern are parsed in lexical order.
+// Errors report the name of the attachment that failed to parse.
+func ParseText(att *ember.Attachments, name string, funcs texttemplate.FuncMap, patterns ...string) (*texttemplate.Template, error) {
+	set := texttemplate.New(name).Funcs(funcs)
+	err := parse(att, patterns, func(name, text string) error {
+		t := set
+		if name != set.Name() {
+			t = set.New(name)
+		}
+		_, err := t.Parse(text)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// ParseHTML parses all attachments matching the given patterns into a new html/template set with the given name.
+//
+// See ParseText for more information.
+func ParseHTML(att *ember.Attachments, name string, funcs htmltemplate.FuncMap, patterns ...string) (*htmltemplate.Template, error) {
+	set := htmltemplate.New(name).Funcs(funcs)
+	err := parse(att, patterns, func(name, text string) error {
+		t := set
+		if name != set.Name() {
+			t = set.New(name)
+		}
+		_, err := t.Parse(text)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// parse reads all attachments matching the patterns and passes them to the parse function.
+func parse(att *ember.Attachments, patterns []string, parseFn func(name, text string) error) error {
+	if len(patterns) == 0 {
+		return errors.New("no patterns given")
+	}
+	names := att.List()
+	sort.Strings(names)
+	parsed := make(map[string]string) // template name -> attachment
+
+	for _, pattern := range patterns {
+		var matches []string
+		for _, name := range names {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				matches = append(matches, name)
+			}
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("pattern %q matches no attachments", pattern)
+		}
+
+		for _, name := range matches {
+			tmplName := path.Base(name)
+			if other, ok := parsed[tmplName]; ok && other != name {
+				return fmt.Errorf("attachments %q and %q both define template %q", other, name, tmplName)
+			}
+			parsed[tmplName] = name
+
+			text, err := io.ReadAll(att.Reader(name))
+			if err == nil {
+				err = parseFn(tmplName, string(text))
+			}
+			if err != nil {
+				return &ember.AttErr{Name: name, Offset: -1, Err: err}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+
+	"github.com/maja42/ember"
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareAttachments returns attachments with the given content, served from an overlay directory.
+func prepareAttachments(t *testing.T, files map[string]string) *ember.Attachments {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	att, err := ember.NewReader(nil, 0, ember.WithOverlay(dir))
+	assert.NoError(t, err)
+	return att
+}
+
+func TestParseText(t *testing.T) {
+	att := prepareAttachments(t, map[string]string{
+		"templates/mail.tmpl":     `Hello {{upper .}}. {{template "footer.tmpl"}}`,
+		"templates/report.tmpl":   `Report`,
+		"partials/footer.tmpl":    `Regards`,
+		"unrelated/other.tmpl":    `Other`,
+		"templates/ignored.txt":   `Ignored`,
+		"templates/sub/deep.tmpl": `Deep`,
+	})
+	funcs := texttemplate.FuncMap{"upper": strings.ToUpper}
+
+	set, err := ParseText(att, "mail", funcs, "templates/*.tmpl", "partials/*.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "mail", set.Name())
+
+	var names []string
+	for _, tmpl := range set.Templates() {
+		names = append(names, tmpl.Name())
+	}
+	assert.ElementsMatch(t, []string{"mail.tmpl", "report.tmpl", "footer.tmpl"}, names)
+
+	var sb strings.Builder
+	assert.NoError(t, set.ExecuteTemplate(&sb, "mail.tmpl", "bob"))
+	assert.Equal(t, "Hello BOB. Regards", sb.String())
+}
+
+func TestParseHTML(t *testing.T) {
+	att := prepareAttachments(t, map[string]string{
+		"page.html": `<p>{{.}}</p>`,
+	})
+
+	set, err := ParseHTML(att, "page.html", nil, "*.html")
+	assert.NoError(t, err)
+
+	var sb strings.Builder
+	assert.NoError(t, set.Execute(&sb, "<script>"))
+	assert.Equal(t, "<p>&lt;script&gt;</p>", sb.String())
+}
+
+func TestParse_errors(t *testing.T) {
+	att := prepareAttachments(t, map[string]string{
+		"templates/valid.tmpl":  `valid`,
+		"templates/broken.tmpl": `{{ .Missing `,
+	})
+
+	_, err := ParseText(att, "set", nil, "templates/*.tmpl")
+	var attErr *ember.AttErr
+	if assert.ErrorAs(t, err, &attErr) {
+		assert.Equal(t, "templates/broken.tmpl", attErr.Name)
+	}
+	assert.ErrorContains(t, err, `attachment "templates/broken.tmpl"`)
+
+	_, err = ParseHTML(att, "set", nil, "templates/*.tmpl")
+	assert.ErrorAs(t, err, &attErr)
+
+	_, err = ParseText(att, "set", nil, "missing/*.tmpl")
+	assert.EqualError(t, err, `pattern "missing/*.tmpl" matches no attachments`)
+
+	_, err = ParseText(att, "set", nil, "[")
+	assert.Error(t, err)
+
+	_, err = ParseText(att, "set", nil)
+	assert.Error(t, err)
+}
+
+func TestParse_duplicateNames(t *testing.T) {
+	att := prepareAttachments(t, map[string]string{
+		"a/index.html": `a`,
+		"b/index.html": `b`,
+	})
+
+	_, err := ParseHTML(att, "set", nil, "*/index.html")
+	assert.EqualError(t, err, `attachments "a/index.html" and "b/index.html" both define template "index.html"`)
+
+	// the same attachment can be matched by multiple patterns
+	set, err := ParseText(att, "set", nil, "a/*", "a/index.html")
+	assert.NoError(t, err)
+	assert.NotNil(t, set.Lookup("index.html"))
+}
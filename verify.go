@@ -0,0 +1,148 @@
+package ember
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/maja42/ember/internal"
+)
+
+// ErrNoDigest is returned when verifying an attachment that was embedded without a digest.
+var ErrNoDigest = errors.New("attachment has no digest")
+
+// ErrChecksumMismatch is returned if the content of an attachment does not match its digest.
+// It matches ErrCorrupt.
+var ErrChecksumMismatch = internal.ErrChecksumMismatch
+
+// Verify reads the content of an attachment and compares it against the digest stored during embedding.
+// Returns ErrChecksumMismatch if the content is corrupt, and ErrNoDigest if the attachment was embedded without digest.
+// Returns ErrNotFound if no attachment with that name exists.
+// Files from the overlay directory have no digest; ErrNoDigest is returned for them.
+func (a *Attachments) Verify(name string) error {
+	r := a.VerifyingReader(name)
+	if r == nil {
+		return &AttErr{Path: a.path, Name: name, Offset: -1, Err: ErrNotFound}
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyAll verifies the content of all attachments.
+// Returns the first error encountered.
+//
+// See Verify for more information.
+func (a *Attachments) VerifyAll() error {
+	for _, name := range a.List() {
+		if err := a.Verify(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyingReader returns a reader for a given attachment that verifies the content while it is read.
+// If the content does not match the stored digest, the final Read returns ErrChecksumMismatch instead of io.EOF.
+// The content is therefore only trustworthy after the reader was consumed completely.
+// If the attachment was embedded without digest, or is served from the overlay directory, the final Read returns ErrNoDigest.
+//
+// Returns nil if no attachment with that name exists.
+func (a *Attachments) VerifyingReader(name string) io.Reader {
+	e, ok := a.entry(name)
+	if !ok {
+		return nil
+	}
+	h, err := a.newDigest(e) // overlay files have no digest
+	if err != nil {
+		return &errReader{err: err, size: e.Size}
+	}
+	return &verifyingReader{
+		err:    func(err error) error { return a.attErr(err, e) },
+		r:      a.Reader(name),
+		hash:   h,
+		digest: e.Digest,
+	}
+}
+
+// newDigest returns the hash for calculating the digest of the given attachment.
+func (a *Attachments) newDigest(e *entry) (hash.Hash, error) {
+	var key []byte
+	if e.Encryption != internal.EncryptionNone {
+		var err error
+		if key, err = a.keys.key(e.KeyID); err != nil {
+			return nil, a.attErr(err, e)
+		}
+	}
+	return internal.NewDigest(e.Encryption, key, e.Salt), nil
+}
+
+// verifyingReader hashes all content read and compares it against the expected digest upon EOF.
+type verifyingReader struct {
+	err    func(error) error // adds details to the reported errors
+	r      io.Reader
+	hash   hash.Hash
+	digest []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if v.digest == nil {
+			return n, v.err(ErrNoDigest)
+		}
+		if !bytes.Equal(v.hash.Sum(nil), v.digest) {
+			return n, v.err(ErrChecksumMismatch)
+		}
+	}
+	return n, err
+}
+
+// ErrUnsigned is returned if trusted keys are configured, but the attachments are not signed.
+// As the signature might have been stripped, it matches ErrCorrupt.
+var ErrUnsigned error = internal.NewCategorizedErr("attachments are not signed", ErrCorrupt)
+
+// ErrInvalidSignature is returned if the signature of the attachments is invalid or created by an untrusted key.
+// It matches ErrCorrupt.
+var ErrInvalidSignature error = internal.NewCategorizedErr("invalid attachment signature", ErrCorrupt)
+
+// VerifySignature ensures that every layer of attachments is signed by one of the given Ed25519 public keys.
+// Unlike WithTrustedKeys, the content of the attachments is not read; use Verify or VerifyAll to compare it
+// against the signed digests. Overlay files are not covered by signatures.
+//
+// Returns ErrUnsigned if a layer is unsigned, or if the executable does not contain any attachments,
+// and ErrInvalidSignature if a signature is invalid or created by an untrusted key.
+func (a *Attachments) VerifySignature(trustedKeys ...ed25519.PublicKey) error {
+	if len(a.layers) == 0 { // the signed attachments might have been stripped
+		return &AttErr{Path: a.path, Offset: -1, Err: ErrUnsigned}
+	}
+	var prevTOC []byte
+	for _, l := range a.layers {
+		if err := verifySignature(l.Signature, l.TOC, prevTOC, trustedKeys); err != nil {
+			return &AttErr{Path: a.path, Offset: l.TOCOffset, Err: err}
+		}
+		prevTOC = l.TOC
+	}
+	return nil
+}
+
+// verifySignature ensures that the TOC was signed by one of the trusted keys.
+// prevTOC is the TOC of the previous layer, or nil for the first layer.
+func verifySignature(signature *internal.Signature, toc, prevTOC []byte, trustedKeys []ed25519.PublicKey) error {
+	if signature == nil {
+		return ErrUnsigned
+	}
+	for _, key := range trustedKeys {
+		if key.Equal(signature.PublicKey) {
+			if signature.Verify(toc, prevTOC) {
+				return nil
+			}
+			break
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,77 @@
+package ember
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/maja42/ember/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func digest(data string) []byte {
+	d := sha256.Sum256([]byte(data))
+	return d[:]
+}
+
+func TestAttachments_Verify(t *testing.T) {
+	var testTOC = internal.TOC{
+		{Name: "valid", Size: 5, Digest: digest("valid")},
+		{Name: "corrupt", Size: 7, Digest: digest("original")},
+		{Name: "nodigest", Size: 8},
+	}
+	path := prepareFile(t, testTOC, [][]byte{
+		[]byte("valid"),
+		[]byte("corrupt"),
+		[]byte("nodigest"),
+	})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	assert.NoError(t, att.Verify("valid"))
+	assert.ErrorIs(t, att.Verify("corrupt"), ErrChecksumMismatch)
+	assert.ErrorIs(t, att.Verify("nodigest"), ErrNoDigest)
+	assert.ErrorIs(t, att.Verify("unknown"), ErrNotFound)
+	assert.ErrorIs(t, att.Verify("unknown"), fs.ErrNotExist)
+
+	var attErr *AttErr
+	if assert.ErrorAs(t, att.Verify("corrupt"), &attErr) {
+		assert.Equal(t, path, attErr.Path)
+		assert.Equal(t, "corrupt", attErr.Name)
+		assert.Equal(t, att.Offset("corrupt"), attErr.Offset)
+	}
+
+	assert.Error(t, att.VerifyAll())
+}
+
+func TestAttachments_VerifyingReader(t *testing.T) {
+	var testTOC = internal.TOC{
+		{Name: "valid", Size: 5, Digest: digest("valid")},
+		{Name: "corrupt", Size: 7, Digest: digest("original")},
+	}
+	path := prepareFile(t, testTOC, [][]byte{
+		[]byte("valid"),
+		[]byte("corrupt"),
+	})
+	defer os.Remove(path)
+
+	att, err := OpenExe(path)
+	assert.NoError(t, err)
+	defer att.Close()
+
+	data, err := io.ReadAll(att.VerifyingReader("valid"))
+	assert.NoError(t, err)
+	assert.Equal(t, "valid", string(data))
+
+	data, err = io.ReadAll(att.VerifyingReader("corrupt"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, "corrupt", string(data))
+
+	assert.NoError(t, att.Verify("valid"))
+	assert.Nil(t, att.VerifyingReader("unknown"))
+}